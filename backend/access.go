@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// ownerKey holds the contract's Ownership, recorded by Init.
+const ownerKey = "owner"
+
+const (
+	// OwnershipTransferredEventName is the name of the event raised when
+	// the owner changes.
+	OwnershipTransferredEventName = "OwnershipTransferred"
+
+	// PausedEventName and UnpausedEventName are the names of the events
+	// raised by Pause and Unpause.
+	PausedEventName   = "Paused"
+	UnpausedEventName = "Unpaused"
+)
+
+// roleRequirements lists the transactions that need a role. It is checked
+// before every transaction, see newChaincode.
+var roleRequirements = kalpext.RoleRequirements{
+	"ImportState":  kalpext.DefaultAdminRole,
+	"SetRoleAdmin": kalpext.DefaultAdminRole,
+	"Pause":        kalpext.DefaultAdminRole,
+	"Unpause":      kalpext.DefaultAdminRole,
+	"SetWriteMode": kalpext.DefaultAdminRole,
+	"Migrate":      kalpext.DefaultAdminRole,
+
+	"SetGreetingPolicy": kalpext.DefaultAdminRole,
+	"ScheduleGreeting":  kalpext.DefaultAdminRole,
+	"SetDefaultLocale":  kalpext.DefaultAdminRole,
+	"SetPaymentPolicy":  kalpext.DefaultAdminRole,
+	"AddBannedTerm":     ModeratorRole,
+	"RemoveBannedTerm":  ModeratorRole,
+}
+
+// Ownership records the owner of the contract. Owner is empty once ownership
+// has been renounced.
+type Ownership struct {
+	Owner string `json:"owner"`
+}
+
+// OwnershipTransferredEvent is the payload of an OwnershipTransferred event.
+// NewOwner is empty when ownership was renounced.
+type OwnershipTransferredEvent struct {
+	PreviousOwner string `json:"previousOwner"`
+	NewOwner      string `json:"newOwner"`
+}
+
+// GetOwner returns the user ID of the owner, or an empty string when
+// ownership has been renounced.
+func (s *SmartContract) GetOwner(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	ownership, err := getOwnership(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ownership == nil {
+		return "", fmt.Errorf("contract is not initialized")
+	}
+	return ownership.Owner, nil
+}
+
+// TransferOwnership makes newOwner the owner. Only the owner can call it.
+// Roles are not transferred with ownership.
+func (s *SmartContract) TransferOwnership(ctx kalpsdk.TransactionContextInterface, newOwner string) error {
+	if newOwner == "" {
+		return fmt.Errorf("new owner must not be empty, use RenounceOwnership instead")
+	}
+	return s.setOwner(ctx, newOwner)
+}
+
+// RenounceOwnership leaves the contract without an owner, which disables the
+// functions only the owner can call. Only the owner can call it.
+func (s *SmartContract) RenounceOwnership(ctx kalpsdk.TransactionContextInterface) error {
+	return s.setOwner(ctx, "")
+}
+
+func (s *SmartContract) setOwner(ctx kalpsdk.TransactionContextInterface, newOwner string) error {
+	previousOwner, err := requireOwner(ctx)
+	if err != nil {
+		return err
+	}
+	if err := putOwnership(ctx, &Ownership{Owner: newOwner}); err != nil {
+		return err
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(OwnershipTransferredEventName, OwnershipTransferredEvent{
+		PreviousOwner: previousOwner,
+		NewOwner:      newOwner,
+	})
+}
+
+// PauseEvent is the payload of Paused and Unpaused events.
+type PauseEvent struct {
+	Account string `json:"account"`
+}
+
+// Pause rejects every state write with kalpext.ErrPaused until Unpause.
+// Queries keep working.
+func (s *SmartContract) Pause(ctx kalpsdk.TransactionContextInterface) error {
+	if err := kalpext.Pause(ctx); err != nil {
+		return err
+	}
+	return emitPauseEvent(ctx, PausedEventName)
+}
+
+// Unpause allows state writes again.
+func (s *SmartContract) Unpause(ctx kalpsdk.TransactionContextInterface) error {
+	if err := kalpext.Unpause(ctx); err != nil {
+		return err
+	}
+	return emitPauseEvent(ctx, UnpausedEventName)
+}
+
+// IsPaused reports whether state writes are currently rejected.
+func (s *SmartContract) IsPaused(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+	return kalpext.IsPaused(ctx)
+}
+
+func emitPauseEvent(ctx kalpsdk.TransactionContextInterface, name string) error {
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return fmt.Errorf("failed to get client id: %v", err)
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(name, PauseEvent{Account: userID})
+}
+
+// HasRole reports whether userID has role.
+func (s *SmartContract) HasRole(ctx kalpsdk.TransactionContextInterface, role string, userID string) (bool, error) {
+	return kalpext.HasRole(ctx, role, userID)
+}
+
+// GetRoleAdmin returns the role that can grant and revoke role.
+func (s *SmartContract) GetRoleAdmin(ctx kalpsdk.TransactionContextInterface, role string) (string, error) {
+	return kalpext.GetRoleAdmin(ctx, role)
+}
+
+// GrantRole gives role to userID. The client needs the admin role of role.
+func (s *SmartContract) GrantRole(ctx kalpsdk.TransactionContextInterface, role string, userID string) error {
+	return kalpext.GrantRole(ctx, role, userID)
+}
+
+// RevokeRole takes role away from userID. The client needs the admin role of
+// role.
+func (s *SmartContract) RevokeRole(ctx kalpsdk.TransactionContextInterface, role string, userID string) error {
+	return kalpext.RevokeRole(ctx, role, userID)
+}
+
+// RenounceRole takes role away from the client.
+func (s *SmartContract) RenounceRole(ctx kalpsdk.TransactionContextInterface, role string) error {
+	return kalpext.RenounceRole(ctx, role)
+}
+
+// SetRoleAdmin makes adminRole the role that can grant and revoke role.
+func (s *SmartContract) SetRoleAdmin(ctx kalpsdk.TransactionContextInterface, role string, adminRole string) error {
+	return kalpext.SetRoleAdmin(ctx, role, adminRole)
+}
+
+// requireOwner returns the owner, or an error unless the client is the
+// owner.
+func requireOwner(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	ownership, err := getOwnership(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ownership == nil {
+		return "", fmt.Errorf("contract is not initialized")
+	}
+	if ownership.Owner == "" {
+		return "", fmt.Errorf("contract has no owner")
+	}
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client id: %v", err)
+	}
+	if userID != ownership.Owner {
+		return "", fmt.Errorf("only the owner can call this function")
+	}
+	return ownership.Owner, nil
+}
+
+// getOwnership returns the recorded Ownership, or nil before Init.
+func getOwnership(ctx kalpsdk.TransactionContextInterface) (*Ownership, error) {
+	ownershipBytes, err := ctx.GetState(ownerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read owner: %v", err)
+	}
+	if ownershipBytes == nil {
+		return nil, nil
+	}
+	var ownership Ownership
+	if err := json.Unmarshal(ownershipBytes, &ownership); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal owner: %v", err)
+	}
+	return &ownership, nil
+}
+
+func putOwnership(ctx kalpsdk.TransactionContextInterface, ownership *Ownership) error {
+	ownershipBytes, err := json.Marshal(ownership)
+	if err != nil {
+		return fmt.Errorf("failed to marshal owner: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(ownerKey, ownershipBytes); err != nil {
+		return fmt.Errorf("failed to store owner: %v", err)
+	}
+	return nil
+}
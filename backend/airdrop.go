@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+	"krc20/merkle"
+)
+
+// AirdropContractName is the name the airdrop contract is served under.
+const AirdropContractName = "airdrop"
+
+const (
+	// airdropObjectType is the composite key type of airdrops, keyed by ID.
+	airdropObjectType = "airdrop"
+
+	// airdropClaimedObjectType is the composite key type of the claimed
+	// bitmap of an airdrop, keyed by airdrop ID and word. Each word holds
+	// claimedWordBits leaves as a hexadecimal number.
+	airdropClaimedObjectType = "airdropClaimed"
+
+	claimedWordBits = 256
+
+	// airdropAccountPrefix starts the account an airdrop is named by in
+	// Transfer events.
+	airdropAccountPrefix = "airdrop/"
+)
+
+const (
+	// AirdropCreatedEventName is the name of the event raised by
+	// CreateAirdrop.
+	AirdropCreatedEventName = "AirdropCreated"
+
+	// AirdropClaimedEventName is the name of the event raised by Claim.
+	AirdropClaimedEventName = "AirdropClaimed"
+
+	// AirdropClosedEventName is the name of the event raised by
+	// CloseAirdrop.
+	AirdropClosedEventName = "AirdropClosed"
+)
+
+// airdropRoleRequirements lists the airdrop transactions that need a role.
+var airdropRoleRequirements = kalpext.RoleRequirements{
+	"CreateAirdrop": kalpext.DefaultAdminRole,
+	"CloseAirdrop":  kalpext.DefaultAdminRole,
+}
+
+// AirdropContract distributes tokens to the leaves of a Merkle tree of
+// allocations. See package merkle for the tree and cmd/airdroptree for
+// building it.
+type AirdropContract struct {
+	kalpsdk.Contract
+}
+
+// Airdrop is a published Merkle root with the Total tokens set aside for it.
+// The unclaimed tokens are held by the airdrop itself rather than a balance;
+// Account only names the airdrop in Transfer events. Claimed is the sum of
+// the claims, which never exceeds Total even when the allocations of the
+// tree add up to more.
+type Airdrop struct {
+	ID         string `json:"id"`
+	Root       string `json:"root"`
+	LeafCount  int    `json:"leafCount"`
+	Total      string `json:"total"`
+	Claimed    string `json:"claimed"`
+	ClaimCount int    `json:"claimCount"`
+	Account    string `json:"account"`
+	Closed     bool   `json:"closed"`
+}
+
+// AirdropCreatedEvent is the payload of an AirdropCreated event.
+type AirdropCreatedEvent struct {
+	AirdropID string `json:"airdropId"`
+	Root      string `json:"root"`
+	LeafCount int    `json:"leafCount"`
+	Total     string `json:"total"`
+	Account   string `json:"account"`
+}
+
+// AirdropClosedEvent is the payload of an AirdropClosed event. Returned is
+// the unclaimed amount paid back to the admin.
+type AirdropClosedEvent struct {
+	AirdropID  string `json:"airdropId"`
+	Claimed    string `json:"claimed"`
+	ClaimCount int    `json:"claimCount"`
+	Returned   string `json:"returned"`
+}
+
+// AirdropClaimedEvent is the payload of an AirdropClaimed event.
+type AirdropClaimedEvent struct {
+	AirdropID string `json:"airdropId"`
+	Index     int    `json:"index"`
+	Account   string `json:"account"`
+	Amount    string `json:"amount"`
+}
+
+// CreateAirdrop publishes the hex-encoded Merkle root of leafCount
+// allocations and sets total of the client's tokens aside for it.
+func (a *AirdropContract) CreateAirdrop(ctx kalpsdk.TransactionContextInterface, airdropID string, root string, leafCount int, total string) error {
+	admin, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if airdropID == "" {
+		return fmt.Errorf("airdrop id must not be empty")
+	}
+	existing, err := getAirdrop(ctx, airdropID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("airdrop %s already exists", airdropID)
+	}
+	if _, err := decodeHash("root", root); err != nil {
+		return err
+	}
+	if leafCount <= 0 {
+		return fmt.Errorf("leaf count must be positive")
+	}
+	value, err := parsePositiveAmount("total", total)
+	if err != nil {
+		return err
+	}
+	airdrop := &Airdrop{
+		ID:        airdropID,
+		Root:      root,
+		LeafCount: leafCount,
+		Total:     value.String(),
+		Claimed:   "0",
+		Account:   airdropAccountPrefix + airdropID,
+	}
+	if err := putAirdrop(ctx, airdrop); err != nil {
+		return err
+	}
+	if err := debitBalance(ctx, admin, value); err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := emitTransfer(events, admin, airdrop.Account, value); err != nil {
+		return err
+	}
+	return events.Emit(AirdropCreatedEventName, AirdropCreatedEvent{
+		AirdropID: airdrop.ID,
+		Root:      airdrop.Root,
+		LeafCount: airdrop.LeafCount,
+		Total:     airdrop.Total,
+		Account:   airdrop.Account,
+	})
+}
+
+// Claim pays the client the amount allocated to it by the leaf at index,
+// given the hex-encoded proof from airdroptree. The claim is added to the
+// airdrop's Claimed and rejected when that would exceed Total, so claims of
+// one airdrop are endorsed one at a time.
+func (a *AirdropContract) Claim(ctx kalpsdk.TransactionContextInterface, airdropID string, index int, amount string, proof []string) error {
+	account, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	airdrop, err := requireAirdrop(ctx, airdropID)
+	if err != nil {
+		return err
+	}
+	if airdrop.Closed {
+		return fmt.Errorf("airdrop %s is closed", airdropID)
+	}
+	if index < 0 || index >= airdrop.LeafCount {
+		return fmt.Errorf("leaf index %d out of range [0, %d)", index, airdrop.LeafCount)
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	root, err := decodeHash("root", airdrop.Root)
+	if err != nil {
+		return err
+	}
+	siblings := make([][]byte, len(proof))
+	for i, sibling := range proof {
+		if siblings[i], err = decodeHash("proof", sibling); err != nil {
+			return err
+		}
+	}
+	if !merkle.Verify(root, airdrop.LeafCount, index, merkle.LeafHash(account, value.String()), siblings) {
+		return fmt.Errorf("invalid proof for %s of %s at leaf %d", account, value, index)
+	}
+
+	claimed, err := isClaimed(ctx, airdropID, index)
+	if err != nil {
+		return err
+	}
+	if claimed {
+		return fmt.Errorf("leaf %d of airdrop %s is already claimed", index, airdropID)
+	}
+	remaining, err := airdrop.remaining()
+	if err != nil {
+		return err
+	}
+	if value.Cmp(remaining) > 0 {
+		return fmt.Errorf("claim of %s exceeds the %s left in airdrop %s", value, remaining, airdropID)
+	}
+	if err := setClaimed(ctx, airdropID, index); err != nil {
+		return err
+	}
+	claimedTotal, err := parseAmount("claimed", airdrop.Claimed)
+	if err != nil {
+		return err
+	}
+	airdrop.Claimed = claimedTotal.Add(claimedTotal, value).String()
+	airdrop.ClaimCount++
+	if err := putAirdrop(ctx, airdrop); err != nil {
+		return err
+	}
+	if err := creditBalance(ctx, account, value); err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := emitTransfer(events, airdrop.Account, account, value); err != nil {
+		return err
+	}
+	return events.Emit(AirdropClaimedEventName, AirdropClaimedEvent{AirdropID: airdropID, Index: index, Account: account, Amount: value.String()})
+}
+
+// IsClaimed reports whether the leaf at index of the airdrop is claimed.
+func (a *AirdropContract) IsClaimed(ctx kalpsdk.TransactionContextInterface, airdropID string, index int) (bool, error) {
+	airdrop, err := requireAirdrop(ctx, airdropID)
+	if err != nil {
+		return false, err
+	}
+	if index < 0 || index >= airdrop.LeafCount {
+		return false, fmt.Errorf("leaf index %d out of range [0, %d)", index, airdrop.LeafCount)
+	}
+	return isClaimed(ctx, airdropID, index)
+}
+
+// GetAirdrop returns the airdrop with the given ID and the tokens claimed
+// from it.
+func (a *AirdropContract) GetAirdrop(ctx kalpsdk.TransactionContextInterface, airdropID string) (*Airdrop, error) {
+	return requireAirdrop(ctx, airdropID)
+}
+
+// CloseAirdrop ends the airdrop and returns its unclaimed tokens to the
+// client.
+func (a *AirdropContract) CloseAirdrop(ctx kalpsdk.TransactionContextInterface, airdropID string) error {
+	admin, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	airdrop, err := requireAirdrop(ctx, airdropID)
+	if err != nil {
+		return err
+	}
+	if airdrop.Closed {
+		return fmt.Errorf("airdrop %s is already closed", airdropID)
+	}
+	airdrop.Closed = true
+	if err := putAirdrop(ctx, airdrop); err != nil {
+		return err
+	}
+	remaining, err := airdrop.remaining()
+	if err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if remaining.Sign() > 0 {
+		if err := creditBalance(ctx, admin, remaining); err != nil {
+			return err
+		}
+		if err := emitTransfer(events, airdrop.Account, admin, remaining); err != nil {
+			return err
+		}
+	}
+	return events.Emit(AirdropClosedEventName, AirdropClosedEvent{
+		AirdropID:  airdrop.ID,
+		Claimed:    airdrop.Claimed,
+		ClaimCount: airdrop.ClaimCount,
+		Returned:   remaining.String(),
+	})
+}
+
+func decodeHash(name, value string) ([]byte, error) {
+	hash, err := hex.DecodeString(value)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid %s %q, expected %d hex-encoded bytes", name, value, sha256.Size)
+	}
+	return hash, nil
+}
+
+func requireAirdrop(ctx kalpsdk.TransactionContextInterface, airdropID string) (*Airdrop, error) {
+	airdrop, err := getAirdrop(ctx, airdropID)
+	if err != nil {
+		return nil, err
+	}
+	if airdrop == nil {
+		return nil, fmt.Errorf("airdrop %s does not exist", airdropID)
+	}
+	return airdrop, nil
+}
+
+func getAirdrop(ctx kalpsdk.TransactionContextInterface, airdropID string) (*Airdrop, error) {
+	key, err := ctx.CreateCompositeKey(airdropObjectType, []string{airdropID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create airdrop key: %v", err)
+	}
+	airdropBytes, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read airdrop: %v", err)
+	}
+	if airdropBytes == nil {
+		return nil, nil
+	}
+	var airdrop Airdrop
+	if err := json.Unmarshal(airdropBytes, &airdrop); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal airdrop: %v", err)
+	}
+	return &airdrop, nil
+}
+
+func putAirdrop(ctx kalpsdk.TransactionContextInterface, airdrop *Airdrop) error {
+	key, err := ctx.CreateCompositeKey(airdropObjectType, []string{airdrop.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create airdrop key: %v", err)
+	}
+	airdropBytes, err := json.Marshal(airdrop)
+	if err != nil {
+		return fmt.Errorf("failed to marshal airdrop: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, airdropBytes); err != nil {
+		return fmt.Errorf("failed to store airdrop: %v", err)
+	}
+	return nil
+}
+
+// remaining returns the tokens of the airdrop that are not claimed yet.
+func (a *Airdrop) remaining() (*big.Int, error) {
+	total, err := parseAmount("total", a.Total)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := parseAmount("claimed", a.Claimed)
+	if err != nil {
+		return nil, err
+	}
+	return total.Sub(total, claimed), nil
+}
+
+// claimedWord returns the key and value of the bitmap word holding index.
+func claimedWord(ctx kalpsdk.TransactionContextInterface, airdropID string, index int) (string, *big.Int, error) {
+	key, err := ctx.CreateCompositeKey(airdropClaimedObjectType, []string{airdropID, strconv.Itoa(index / claimedWordBits)})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create claimed key: %v", err)
+	}
+	wordBytes, err := ctx.GetState(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read claimed leaves: %v", err)
+	}
+	word := new(big.Int)
+	if wordBytes != nil {
+		if _, ok := word.SetString(string(wordBytes), 16); !ok {
+			return "", nil, fmt.Errorf("invalid claimed leaves stored under %q", key)
+		}
+	}
+	return key, word, nil
+}
+
+func isClaimed(ctx kalpsdk.TransactionContextInterface, airdropID string, index int) (bool, error) {
+	_, word, err := claimedWord(ctx, airdropID, index)
+	if err != nil {
+		return false, err
+	}
+	return word.Bit(index%claimedWordBits) == 1, nil
+}
+
+func setClaimed(ctx kalpsdk.TransactionContextInterface, airdropID string, index int) error {
+	key, word, err := claimedWord(ctx, airdropID, index)
+	if err != nil {
+		return err
+	}
+	word.SetBit(word, index%claimedWordBits, 1)
+	if err := ctx.PutStateWithoutKYC(key, []byte(word.Text(16))); err != nil {
+		return fmt.Errorf("failed to store claimed leaves: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+const (
+	// allowanceObjectType is the composite key type of allowances, keyed by
+	// owner and spender.
+	allowanceObjectType = "allowance"
+
+	// maxAllowancePageSize caps the allowances returned by ListAllowances.
+	maxAllowancePageSize = 100
+)
+
+// Allowance lets Spender transfer up to Amount of Owner's tokens with
+// TransferFrom. ExpiresAt, an RFC 3339 time, ends it at that transaction
+// timestamp, and PerTxLimit caps each TransferFrom. Both are empty when
+// unlimited.
+type Allowance struct {
+	Owner      string `json:"owner"`
+	Spender    string `json:"spender"`
+	Amount     string `json:"amount"`
+	ExpiresAt  string `json:"expiresAt,omitempty" metadata:",optional"`
+	PerTxLimit string `json:"perTxLimit,omitempty" metadata:",optional"`
+}
+
+// AllowancePage is one page of ListAllowances. Bookmark is passed to the
+// next call and is empty on the last page.
+type AllowancePage struct {
+	Allowances []Allowance `json:"allowances"`
+	Bookmark   string      `json:"bookmark"`
+}
+
+// Approve lets spender transfer up to amount of the client's tokens, without
+// expiry or per-transaction limit. It replaces any earlier allowance.
+func (t *TokenContract) Approve(ctx kalpsdk.TransactionContextInterface, spender string, amount string) error {
+	return t.ApproveWithLimits(ctx, spender, amount, "", "")
+}
+
+// ApproveWithLimits is Approve with an RFC 3339 expiry and a limit on each
+// TransferFrom. Either may be empty for none. It replaces any earlier
+// allowance.
+func (t *TokenContract) ApproveWithLimits(ctx kalpsdk.TransactionContextInterface, spender string, amount string, expiresAt string, perTxLimit string) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if spender == "" || spender == owner {
+		return fmt.Errorf("spender must be another account")
+	}
+	value, err := parseAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	allowance := Allowance{Owner: owner, Spender: spender, Amount: value.String()}
+	expiry, err := parseOptionalTime("expiresAt", expiresAt)
+	if err != nil {
+		return err
+	}
+	if !expiry.IsZero() {
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+		if !expiry.After(now) {
+			return fmt.Errorf("expiresAt must be in the future")
+		}
+		allowance.ExpiresAt = formatOptionalTime(expiry)
+	}
+	if perTxLimit != "" {
+		limit, err := parsePositiveAmount("perTxLimit", perTxLimit)
+		if err != nil {
+			return err
+		}
+		allowance.PerTxLimit = limit.String()
+	}
+	if value.Sign() == 0 {
+		if err := deleteAllowance(ctx, owner, spender); err != nil {
+			return err
+		}
+	} else if err := putAllowance(ctx, &allowance); err != nil {
+		return err
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(ApprovalEventName, ApprovalEvent{Owner: owner, Spender: spender, Value: value.String()})
+}
+
+// RevokeAllowance removes the allowance the client gave spender.
+func (t *TokenContract) RevokeAllowance(ctx kalpsdk.TransactionContextInterface, spender string) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	allowance, err := getAllowance(ctx, owner, spender)
+	if err != nil {
+		return err
+	}
+	if allowance == nil {
+		return fmt.Errorf("no allowance for %s", spender)
+	}
+	if err := deleteAllowance(ctx, owner, spender); err != nil {
+		return err
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(ApprovalEventName, ApprovalEvent{Owner: owner, Spender: spender, Value: "0"})
+}
+
+// Allowance returns how many of owner's tokens spender can still transfer,
+// zero once the allowance has expired.
+func (t *TokenContract) Allowance(ctx kalpsdk.TransactionContextInterface, owner string, spender string) (string, error) {
+	allowance, err := activeAllowance(ctx, owner, spender)
+	if err != nil {
+		return "", err
+	}
+	if allowance == nil {
+		return "0", nil
+	}
+	return allowance.Amount, nil
+}
+
+// GetAllowance returns the allowance owner gave spender with its limits. It
+// fails when there is none or it has expired.
+func (t *TokenContract) GetAllowance(ctx kalpsdk.TransactionContextInterface, owner string, spender string) (*Allowance, error) {
+	allowance, err := activeAllowance(ctx, owner, spender)
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		return nil, fmt.Errorf("%s has no active allowance from %s", spender, owner)
+	}
+	return allowance, nil
+}
+
+// activeAllowance returns the allowance owner gave spender, or nil when
+// there is none or it has expired.
+func activeAllowance(ctx kalpsdk.TransactionContextInterface, owner, spender string) (*Allowance, error) {
+	allowance, err := getAllowance(ctx, owner, spender)
+	if err != nil || allowance == nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active, err := allowance.activeAt(now)
+	if err != nil || !active {
+		return nil, err
+	}
+	return allowance, nil
+}
+
+// TransferFrom moves amount of from's tokens to to, spending the allowance
+// from gave the client.
+func (t *TokenContract) TransferFrom(ctx kalpsdk.TransactionContextInterface, from string, to string, amount string) error {
+	spender, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		return fmt.Errorf("recipient must not be empty")
+	}
+	if err := spendAllowance(ctx, from, spender, value); err != nil {
+		return err
+	}
+	return moveTokens(ctx, kalpext.NewEventBuffer(ctx), from, to, value)
+}
+
+// ListAllowances returns the active allowances among the next pageSize owner
+// has given, ordered by spender, starting after the spender in bookmark. A
+// page holds fewer than pageSize when some of them have expired.
+func (t *TokenContract) ListAllowances(ctx kalpsdk.TransactionContextInterface, owner string, bookmark string, pageSize int) (*AllowancePage, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("owner must not be empty")
+	}
+	if pageSize <= 0 || pageSize > maxAllowancePageSize {
+		pageSize = maxAllowancePageSize
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iterator, more, err := kalpext.PartialCompositeKeyPage(ctx, allowanceObjectType, []string{owner}, bookmark, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	page := &AllowancePage{Allowances: []Allowance{}}
+	lastSpender := ""
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read allowances: %v", err)
+		}
+		_, attributes, err := ctx.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 2 {
+			return nil, fmt.Errorf("invalid allowance key %q", kv.Key)
+		}
+		lastSpender = attributes[1]
+		var allowance Allowance
+		if err := json.Unmarshal(kv.Value, &allowance); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal allowance: %v", err)
+		}
+		active, err := allowance.activeAt(now)
+		if err != nil {
+			return nil, err
+		}
+		if active {
+			page.Allowances = append(page.Allowances, allowance)
+		}
+	}
+	if more {
+		page.Bookmark = lastSpender
+	}
+	return page, nil
+}
+
+// spendAllowance deducts value from the allowance owner gave spender.
+func spendAllowance(ctx kalpsdk.TransactionContextInterface, owner, spender string, value *big.Int) error {
+	allowance, err := getAllowance(ctx, owner, spender)
+	if err != nil {
+		return err
+	}
+	if allowance == nil {
+		return fmt.Errorf("%s has no allowance from %s", spender, owner)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	active, err := allowance.activeAt(now)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return fmt.Errorf("allowance of %s from %s expired at %s", spender, owner, allowance.ExpiresAt)
+	}
+	if allowance.PerTxLimit != "" {
+		limit, err := parseAmount("perTxLimit", allowance.PerTxLimit)
+		if err != nil {
+			return err
+		}
+		if value.Cmp(limit) > 0 {
+			return fmt.Errorf("amount %s exceeds the per-transaction limit of %s", value, limit)
+		}
+	}
+	remaining, err := parseAmount("allowance", allowance.Amount)
+	if err != nil {
+		return err
+	}
+	if value.Cmp(remaining) > 0 {
+		return fmt.Errorf("amount %s exceeds the allowance of %s", value, remaining)
+	}
+	remaining.Sub(remaining, value)
+	if remaining.Sign() == 0 {
+		return deleteAllowance(ctx, owner, spender)
+	}
+	allowance.Amount = remaining.String()
+	return putAllowance(ctx, allowance)
+}
+
+// activeAt reports whether the allowance has not expired at now.
+func (a *Allowance) activeAt(now time.Time) (bool, error) {
+	expiry, err := parseOptionalTime("expiresAt", a.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	return expiry.IsZero() || now.Before(expiry), nil
+}
+
+func getAllowance(ctx kalpsdk.TransactionContextInterface, owner, spender string) (*Allowance, error) {
+	key, err := ctx.CreateCompositeKey(allowanceObjectType, []string{owner, spender})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create allowance key: %v", err)
+	}
+	allowanceBytes, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowance: %v", err)
+	}
+	if allowanceBytes == nil {
+		return nil, nil
+	}
+	var allowance Allowance
+	if err := json.Unmarshal(allowanceBytes, &allowance); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal allowance: %v", err)
+	}
+	return &allowance, nil
+}
+
+func putAllowance(ctx kalpsdk.TransactionContextInterface, allowance *Allowance) error {
+	key, err := ctx.CreateCompositeKey(allowanceObjectType, []string{allowance.Owner, allowance.Spender})
+	if err != nil {
+		return fmt.Errorf("failed to create allowance key: %v", err)
+	}
+	allowanceBytes, err := json.Marshal(allowance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allowance: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, allowanceBytes); err != nil {
+		return fmt.Errorf("failed to store allowance: %v", err)
+	}
+	return nil
+}
+
+func deleteAllowance(ctx kalpsdk.TransactionContextInterface, owner, spender string) error {
+	key, err := ctx.CreateCompositeKey(allowanceObjectType, []string{owner, spender})
+	if err != nil {
+		return fmt.Errorf("failed to create allowance key: %v", err)
+	}
+	if err := ctx.DelStateWithoutKYC(key); err != nil {
+		return fmt.Errorf("failed to delete allowance: %v", err)
+	}
+	return nil
+}
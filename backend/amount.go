@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Token amounts are integers in the token's smallest unit. They are passed
+// and stored as decimal strings, so that they are not limited to 64 bits and
+// survive JSON clients that read numbers as floats.
+
+// parseAmount parses a non-negative amount. name is used in errors.
+func parseAmount(name, value string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %q, expected a whole number", name, value)
+	}
+	if amount.Sign() < 0 {
+		return nil, fmt.Errorf("%s must not be negative", name)
+	}
+	return amount, nil
+}
+
+// parsePositiveAmount parses an amount that must be greater than zero.
+func parsePositiveAmount(name, value string) (*big.Int, error) {
+	amount, err := parseAmount(name, value)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Sign() == 0 {
+		return nil, fmt.Errorf("%s must be positive", name)
+	}
+	return amount, nil
+}
+
+// getAmount reads the amount stored under key, zero when there is none.
+func getAmount(ctx kalpsdk.TransactionContextInterface, key string) (*big.Int, error) {
+	value, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", key, err)
+	}
+	if value == nil {
+		return new(big.Int), nil
+	}
+	amount, ok := new(big.Int).SetString(string(value), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount stored under %q", key)
+	}
+	return amount, nil
+}
+
+// putAmount stores amount under key, deleting the key for zero.
+func putAmount(ctx kalpsdk.TransactionContextInterface, key string, amount *big.Int) error {
+	if amount.Sign() == 0 {
+		if err := ctx.DelStateWithoutKYC(key); err != nil {
+			return fmt.Errorf("failed to delete %q: %v", key, err)
+		}
+		return nil
+	}
+	if err := ctx.PutStateWithoutKYC(key, []byte(amount.String())); err != nil {
+		return fmt.Errorf("failed to store %q: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// newChaincode creates the chaincode with every contract it serves. It is
+// shared by the chaincode server in main.go and the simulator in sim.go. The
+// greeting contract comes first, so it also serves function names without a
+// contract prefix.
+func newChaincode() (*kalpsdk.ContractChaincode, error) {
+	return kalpsdk.NewChaincode(
+		&SmartContract{newContract("", roleRequirements)},
+		&TokenContract{newContract(TokenContractName, tokenRoleRequirements)},
+		&AirdropContract{newContract(AirdropContractName, airdropRoleRequirements)},
+		&VestingContract{newContract(VestingContractName, vestingRoleRequirements)},
+		&StakingContract{newContract(StakingContractName, stakingRoleRequirements)},
+		&NFTContract{newContract(NFTContractName, nftRoleRequirements)},
+		&MultiTokenContract{newContract(MultiTokenContractName, multiTokenRoleRequirements)},
+		&EscrowContract{newContract(EscrowContractName, nil)},
+	)
+}
+
+// newContract returns the base of every contract in the chaincode, named
+// name and enforcing requirements before each transaction. An empty name
+// names the contract after its type.
+func newContract(name string, requirements kalpext.RoleRequirements) kalpsdk.Contract {
+	// A payable contract expects a payment with every transaction, queries
+	// included. Paid greetings check their payment in SetPaidGreeting instead.
+	contract := kalpsdk.Contract{IsPayableContract: false}
+	contract.Name = name
+	contract.Logger = kalpsdk.NewLogger()
+	contract.BeforeTransaction = requirements.Check
+	contract.TransactionContextHandler = new(kalpext.PausableTransactionContext)
+	return contract
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+const (
+	// tokenSnapshotKey holds the ID of the latest token snapshot.
+	tokenSnapshotKey = "tokenSnapshot"
+
+	// balanceCheckpointObjectType is the composite key type of balance
+	// checkpoints, keyed by account and snapshot ID. A checkpoint holds the
+	// balance the account had when the snapshot was taken.
+	balanceCheckpointObjectType = "balanceCheckpoint"
+
+	// supplyCheckpointObjectType is the composite key type of total supply
+	// checkpoints, keyed by snapshot ID.
+	supplyCheckpointObjectType = "supplyCheckpoint"
+)
+
+// SnapshotEventName is the name of the event raised by CreateSnapshot.
+const SnapshotEventName = "Snapshot"
+
+// SnapshotEvent is the payload of a Snapshot event.
+type SnapshotEvent struct {
+	ID        int    `json:"id"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Snapshots record balances as of a point in time without copying them.
+// CreateSnapshot only advances the snapshot ID. The first time a balance or
+// the total supply changes after that, its value before the change is
+// written as a checkpoint for the latest snapshot. The value at a snapshot is
+// then the first checkpoint at or after it, or the current value when there
+// is none because nothing changed since.
+
+// CreateSnapshot records the current balances and total supply under a new
+// snapshot ID, which it returns.
+func (t *TokenContract) CreateSnapshot(ctx kalpsdk.TransactionContextInterface) (int, error) {
+	id, err := currentSnapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+	id++
+	if err := ctx.PutStateWithoutKYC(tokenSnapshotKey, []byte(strconv.Itoa(id))); err != nil {
+		return 0, fmt.Errorf("failed to store snapshot id: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := kalpext.NewEventBuffer(ctx).Emit(SnapshotEventName, SnapshotEvent{ID: id, Timestamp: formatOptionalTime(now)}); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetCurrentSnapshotID returns the ID of the latest snapshot, 0 before the
+// first.
+func (t *TokenContract) GetCurrentSnapshotID(ctx kalpsdk.TransactionContextInterface) (int, error) {
+	return currentSnapshot(ctx)
+}
+
+// BalanceOfAt returns the tokens account held when snapshotID was taken.
+func (t *TokenContract) BalanceOfAt(ctx kalpsdk.TransactionContextInterface, account string, snapshotID int) (string, error) {
+	if err := validateSnapshot(ctx, snapshotID); err != nil {
+		return "", err
+	}
+	balance, err := checkpointAt(ctx, balanceCheckpointObjectType, []string{account}, snapshotID)
+	if err != nil || balance != nil {
+		return balance.String(), err
+	}
+	return t.BalanceOf(ctx, account)
+}
+
+// TotalSupplyAt returns the total supply when snapshotID was taken.
+func (t *TokenContract) TotalSupplyAt(ctx kalpsdk.TransactionContextInterface, snapshotID int) (string, error) {
+	if err := validateSnapshot(ctx, snapshotID); err != nil {
+		return "", err
+	}
+	supply, err := checkpointAt(ctx, supplyCheckpointObjectType, []string{}, snapshotID)
+	if err != nil || supply != nil {
+		return supply.String(), err
+	}
+	return t.TotalSupply(ctx)
+}
+
+func currentSnapshot(ctx kalpsdk.TransactionContextInterface) (int, error) {
+	idBytes, err := ctx.GetState(tokenSnapshotKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot id: %v", err)
+	}
+	if idBytes == nil {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(string(idBytes))
+	if err != nil {
+		return 0, fmt.Errorf("invalid snapshot id stored: %v", err)
+	}
+	return id, nil
+}
+
+func validateSnapshot(ctx kalpsdk.TransactionContextInterface, snapshotID int) error {
+	current, err := currentSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if snapshotID <= 0 || snapshotID > current {
+		return fmt.Errorf("snapshot %d does not exist", snapshotID)
+	}
+	return nil
+}
+
+// snapshotAttribute formats a snapshot ID as a composite key attribute that
+// sorts numerically.
+func snapshotAttribute(id int) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+// checkpointAt returns the first checkpoint of objectType under attributes
+// at or after snapshotID, or nil when there is none.
+func checkpointAt(ctx kalpsdk.TransactionContextInterface, objectType string, attributes []string, snapshotID int) (*big.Int, error) {
+	iterator, err := ctx.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %v", err)
+	}
+	defer iterator.Close()
+
+	want := snapshotAttribute(snapshotID)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read checkpoints: %v", err)
+		}
+		_, keyAttributes, err := ctx.SplitCompositeKey(kv.Key)
+		if err != nil || len(keyAttributes) != len(attributes)+1 {
+			return nil, fmt.Errorf("invalid checkpoint key %q", kv.Key)
+		}
+		if keyAttributes[len(attributes)] < want {
+			continue
+		}
+		value, ok := new(big.Int).SetString(string(kv.Value), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid checkpoint stored under %q", kv.Key)
+		}
+		return value, nil
+	}
+	return nil, nil
+}
+
+// writeCheckpoint records value, read under key before a change, as the
+// checkpoint of objectType under attributes for the latest snapshot, unless
+// it already has one.
+func writeCheckpoint(ctx kalpsdk.TransactionContextInterface, objectType string, attributes []string, key string) error {
+	id, err := currentSnapshot(ctx)
+	if err != nil || id == 0 {
+		return err
+	}
+	checkpointKey, err := ctx.CreateCompositeKey(objectType, append(attributes, snapshotAttribute(id)))
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint key: %v", err)
+	}
+	existing, err := ctx.GetState(checkpointKey)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	if existing != nil {
+		return nil
+	}
+	value, err := getAmount(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := ctx.PutStateWithoutKYC(checkpointKey, []byte(value.String())); err != nil {
+		return fmt.Errorf("failed to store checkpoint: %v", err)
+	}
+	return nil
+}
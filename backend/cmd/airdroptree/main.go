@@ -0,0 +1,121 @@
+// Command airdroptree builds the Merkle tree of an airdrop from a CSV of
+// allocations and prints its root and every user's claim as JSON.
+//
+// Usage:
+//
+//	airdroptree allocations.csv > airdrop.json
+//
+// Each CSV row holds a user ID and a whole token amount in the token's
+// smallest unit. A first row whose amount is not a number is taken as a
+// header and skipped. The output holds the root and leaf count to pass to
+// airdrop:CreateAirdrop, the total to fund it with, and the index, amount and
+// proof each user passes to airdrop:Claim.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"krc20/merkle"
+)
+
+// Airdrop is the output of airdroptree.
+type Airdrop struct {
+	Root      string  `json:"root"`
+	LeafCount int     `json:"leafCount"`
+	Total     string  `json:"total"`
+	Claims    []Claim `json:"claims"`
+}
+
+// Claim is what one user passes to airdrop:Claim.
+type Claim struct {
+	Index  int      `json:"index"`
+	UserID string   `json:"userID"`
+	Amount string   `json:"amount"`
+	Proof  []string `json:"proof"`
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: airdroptree allocations.csv\n")
+		os.Exit(2)
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "airdroptree: %v\n", err)
+		os.Exit(2)
+	}
+	defer f.Close()
+
+	airdrop, err := build(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "airdroptree: %v\n", err)
+		os.Exit(1)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(airdrop); err != nil {
+		fmt.Fprintf(os.Stderr, "airdroptree: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func build(r io.Reader) (*Airdrop, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	airdrop := &Airdrop{Claims: []Claim{}}
+	total := new(big.Int)
+	seen := make(map[string]int)
+	var leaves [][]byte
+	for i, row := range rows {
+		userID, amountText := strings.TrimSpace(row[0]), strings.TrimSpace(row[1])
+		amount, ok := new(big.Int).SetString(amountText, 10)
+		if !ok && i == 0 {
+			continue
+		}
+		if !ok || amount.Sign() <= 0 {
+			return nil, fmt.Errorf("line %d: invalid amount %q, expected a positive whole number", i+1, amountText)
+		}
+		if userID == "" {
+			return nil, fmt.Errorf("line %d: empty user ID", i+1)
+		}
+		if line, ok := seen[userID]; ok {
+			return nil, fmt.Errorf("line %d: user %s is already allocated on line %d", i+1, userID, line)
+		}
+		seen[userID] = i + 1
+		total.Add(total, amount)
+		airdrop.Claims = append(airdrop.Claims, Claim{Index: len(leaves), UserID: userID, Amount: amount.String()})
+		leaves = append(leaves, merkle.LeafHash(userID, amount.String()))
+	}
+
+	tree, err := merkle.New(leaves)
+	if err != nil {
+		return nil, err
+	}
+	for i := range airdrop.Claims {
+		proof, err := tree.Proof(i)
+		if err != nil {
+			return nil, err
+		}
+		airdrop.Claims[i].Proof = make([]string, len(proof))
+		for j, sibling := range proof {
+			airdrop.Claims[i].Proof[j] = hex.EncodeToString(sibling)
+		}
+	}
+	airdrop.Root = hex.EncodeToString(tree.Root())
+	airdrop.LeafCount = tree.Len()
+	airdrop.Total = total.String()
+	return airdrop, nil
+}
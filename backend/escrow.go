@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// EscrowContractName is the name the escrow contract is served under.
+const EscrowContractName = "escrow"
+
+const (
+	// escrowObjectType is the composite key type of escrows, keyed by ID.
+	escrowObjectType = "escrow"
+
+	// escrowAccountPrefix starts the token account that holds the tokens of
+	// an escrow.
+	escrowAccountPrefix = "escrow/"
+
+	// escrowDisputePeriod is how long the arbiter has to resolve a dispute
+	// before the buyer can claim a refund.
+	escrowDisputePeriod = 14 * 24 * time.Hour
+)
+
+// EscrowStateChangedEventName is the name of the event raised on every
+// state transition of an escrow, including its creation.
+const EscrowStateChangedEventName = "EscrowStateChanged"
+
+// Escrow states.
+const (
+	// EscrowFunded holds the buyer's tokens until the buyer releases them,
+	// the seller refunds them, either disputes, or the deadline passes.
+	EscrowFunded = "FUNDED"
+
+	// EscrowDisputed holds the tokens until the arbiter decides, the seller
+	// refunds them, or the dispute deadline passes.
+	EscrowDisputed = "DISPUTED"
+
+	// EscrowReleased paid the tokens to the seller.
+	EscrowReleased = "RELEASED"
+
+	// EscrowRefunded returned the tokens to the buyer.
+	EscrowRefunded = "REFUNDED"
+)
+
+// escrowTransitions lists the states each state can move to. RELEASED and
+// REFUNDED are final.
+var escrowTransitions = map[string][]string{
+	"":             {EscrowFunded},
+	EscrowFunded:   {EscrowReleased, EscrowRefunded, EscrowDisputed},
+	EscrowDisputed: {EscrowReleased, EscrowRefunded},
+}
+
+// EscrowContract holds a buyer's tokens for a seller until delivery.
+type EscrowContract struct {
+	kalpsdk.Contract
+}
+
+// Escrow is a deposit of Amount tokens by Buyer for Seller, held in Account.
+// Deadline is an RFC 3339 time after which the buyer can be refunded.
+// Arbiter, when set, decides disputes. DisputeDeadline is set by Dispute; once
+// it passes without a decision the buyer can be refunded, so an absent
+// arbiter cannot lock the tokens.
+type Escrow struct {
+	ID              string `json:"id"`
+	Buyer           string `json:"buyer"`
+	Seller          string `json:"seller"`
+	Arbiter         string `json:"arbiter,omitempty" metadata:",optional"`
+	Amount          string `json:"amount"`
+	Deadline        string `json:"deadline"`
+	DisputeDeadline string `json:"disputeDeadline,omitempty" metadata:",optional"`
+	State           string `json:"state"`
+	Account         string `json:"account"`
+}
+
+// EscrowStateChangedEvent is the payload of an EscrowStateChanged event. From
+// is empty when the escrow is created.
+type EscrowStateChangedEvent struct {
+	EscrowID string `json:"escrowId"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Actor    string `json:"actor"`
+}
+
+// CreateEscrow moves amount of the client's tokens into an escrow for seller
+// until deadline, an RFC 3339 time. arbiter may be empty. It returns the
+// escrow ID, the ID of the transaction.
+func (e *EscrowContract) CreateEscrow(ctx kalpsdk.TransactionContextInterface, seller string, arbiter string, amount string, deadline string) (string, error) {
+	buyer, err := clientID(ctx)
+	if err != nil {
+		return "", err
+	}
+	if seller == "" || seller == buyer {
+		return "", fmt.Errorf("seller must be another account")
+	}
+	if arbiter == buyer || arbiter == seller {
+		return "", fmt.Errorf("arbiter must not be the buyer or the seller")
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return "", err
+	}
+	deadlineTime, err := parseOptionalTime("deadline", deadline)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !deadlineTime.After(now) {
+		return "", fmt.Errorf("deadline must be in the future")
+	}
+	escrow := &Escrow{
+		ID:       ctx.GetTxID(),
+		Buyer:    buyer,
+		Seller:   seller,
+		Arbiter:  arbiter,
+		Amount:   value.String(),
+		Deadline: formatOptionalTime(deadlineTime),
+	}
+	escrow.Account = escrowAccountPrefix + escrow.ID
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, buyer, escrow.Account, value); err != nil {
+		return "", err
+	}
+	if err := transitionEscrow(ctx, events, escrow, EscrowFunded, buyer); err != nil {
+		return "", err
+	}
+	return escrow.ID, nil
+}
+
+// Release pays the escrowed tokens to the seller. Only the buyer can release
+// a funded escrow.
+func (e *EscrowContract) Release(ctx kalpsdk.TransactionContextInterface, escrowID string) error {
+	actor, escrow, err := escrowForActor(ctx, escrowID)
+	if err != nil {
+		return err
+	}
+	if actor != escrow.Buyer {
+		return fmt.Errorf("only the buyer can release escrow %s", escrowID)
+	}
+	if escrow.State != EscrowFunded {
+		return fmt.Errorf("escrow %s is %s, only a funded escrow can be released", escrowID, escrow.State)
+	}
+	return settleEscrow(ctx, escrow, EscrowReleased, actor)
+}
+
+// Refund returns the escrowed tokens to the buyer. Only the seller can
+// refund, also while a dispute is open.
+func (e *EscrowContract) Refund(ctx kalpsdk.TransactionContextInterface, escrowID string) error {
+	actor, escrow, err := escrowForActor(ctx, escrowID)
+	if err != nil {
+		return err
+	}
+	if actor != escrow.Seller {
+		return fmt.Errorf("only the seller can refund escrow %s", escrowID)
+	}
+	return settleEscrow(ctx, escrow, EscrowRefunded, actor)
+}
+
+// Dispute hands a funded escrow to its arbiter, who has escrowDisputePeriod
+// to resolve it. The buyer or the seller can dispute before the deadline.
+func (e *EscrowContract) Dispute(ctx kalpsdk.TransactionContextInterface, escrowID string) error {
+	actor, escrow, err := escrowForActor(ctx, escrowID)
+	if err != nil {
+		return err
+	}
+	if actor != escrow.Buyer && actor != escrow.Seller {
+		return fmt.Errorf("only the buyer or the seller can dispute escrow %s", escrowID)
+	}
+	if escrow.Arbiter == "" {
+		return fmt.Errorf("escrow %s has no arbiter", escrowID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	passed, err := timePassed("deadline", escrow.Deadline, now)
+	if err != nil {
+		return err
+	}
+	if passed {
+		return fmt.Errorf("the deadline of escrow %s has passed", escrowID)
+	}
+	escrow.DisputeDeadline = formatOptionalTime(now.Add(escrowDisputePeriod))
+	return transitionEscrow(ctx, kalpext.NewEventBuffer(ctx), escrow, EscrowDisputed, actor)
+}
+
+// Resolve settles a disputed escrow. The arbiter pays the seller when
+// releaseToSeller is set and refunds the buyer otherwise.
+func (e *EscrowContract) Resolve(ctx kalpsdk.TransactionContextInterface, escrowID string, releaseToSeller bool) error {
+	actor, escrow, err := escrowForActor(ctx, escrowID)
+	if err != nil {
+		return err
+	}
+	if escrow.Arbiter == "" || actor != escrow.Arbiter {
+		return fmt.Errorf("only the arbiter can resolve escrow %s", escrowID)
+	}
+	if escrow.State != EscrowDisputed {
+		return fmt.Errorf("escrow %s is %s, only a disputed escrow can be resolved", escrowID, escrow.State)
+	}
+	if releaseToSeller {
+		return settleEscrow(ctx, escrow, EscrowReleased, actor)
+	}
+	return settleEscrow(ctx, escrow, EscrowRefunded, actor)
+}
+
+// ClaimRefund returns the tokens of a funded escrow to the buyer once its
+// deadline has passed, or of a disputed escrow once its dispute deadline has
+// passed without the arbiter resolving it. Anyone can call it.
+func (e *EscrowContract) ClaimRefund(ctx kalpsdk.TransactionContextInterface, escrowID string) error {
+	actor, escrow, err := escrowForActor(ctx, escrowID)
+	if err != nil {
+		return err
+	}
+	name, deadline := "deadline", escrow.Deadline
+	switch escrow.State {
+	case EscrowFunded:
+	case EscrowDisputed:
+		name, deadline = "dispute deadline", escrow.DisputeDeadline
+	default:
+		return fmt.Errorf("escrow %s is %s, only a funded or disputed escrow can be refunded after its deadline", escrowID, escrow.State)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	passed, err := timePassed(name, deadline, now)
+	if err != nil {
+		return err
+	}
+	if !passed {
+		return fmt.Errorf("escrow %s can be refunded from %s", escrowID, deadline)
+	}
+	return settleEscrow(ctx, escrow, EscrowRefunded, actor)
+}
+
+// GetEscrow returns the escrow with the given ID.
+func (e *EscrowContract) GetEscrow(ctx kalpsdk.TransactionContextInterface, escrowID string) (*Escrow, error) {
+	return requireEscrow(ctx, escrowID)
+}
+
+// timePassed reports whether now is at or after the RFC 3339 time deadline.
+// name is used in errors.
+func timePassed(name, deadline string, now time.Time) (bool, error) {
+	t, err := parseOptionalTime(name, deadline)
+	if err != nil {
+		return false, err
+	}
+	if t.IsZero() {
+		return false, fmt.Errorf("%s is not set", name)
+	}
+	return !now.Before(t), nil
+}
+
+// settleEscrow pays the escrowed tokens to the seller for EscrowReleased or
+// the buyer for EscrowRefunded and moves the escrow to that state.
+func settleEscrow(ctx kalpsdk.TransactionContextInterface, escrow *Escrow, state, actor string) error {
+	recipient := escrow.Buyer
+	if state == EscrowReleased {
+		recipient = escrow.Seller
+	}
+	value, err := parseAmount("amount", escrow.Amount)
+	if err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := transitionEscrow(ctx, events, escrow, state, actor); err != nil {
+		return err
+	}
+	return moveTokens(ctx, events, escrow.Account, recipient, value)
+}
+
+// transitionEscrow moves escrow to state, stores it and raises an
+// EscrowStateChanged event in events. It fails for a transition that
+// escrowTransitions does not list.
+func transitionEscrow(ctx kalpsdk.TransactionContextInterface, events *kalpext.EventBuffer, escrow *Escrow, state, actor string) error {
+	allowed := false
+	for _, next := range escrowTransitions[escrow.State] {
+		if next == state {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("escrow %s cannot move from %q to %q", escrow.ID, escrow.State, state)
+	}
+	from := escrow.State
+	escrow.State = state
+	if err := putEscrow(ctx, escrow); err != nil {
+		return err
+	}
+	return events.Emit(EscrowStateChangedEventName, EscrowStateChangedEvent{EscrowID: escrow.ID, From: from, To: state, Actor: actor})
+}
+
+func escrowForActor(ctx kalpsdk.TransactionContextInterface, escrowID string) (string, *Escrow, error) {
+	actor, err := clientID(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	escrow, err := requireEscrow(ctx, escrowID)
+	if err != nil {
+		return "", nil, err
+	}
+	return actor, escrow, nil
+}
+
+func requireEscrow(ctx kalpsdk.TransactionContextInterface, escrowID string) (*Escrow, error) {
+	key, err := ctx.CreateCompositeKey(escrowObjectType, []string{escrowID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create escrow key: %v", err)
+	}
+	escrowBytes, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read escrow: %v", err)
+	}
+	if escrowBytes == nil {
+		return nil, fmt.Errorf("escrow %s does not exist", escrowID)
+	}
+	var escrow Escrow
+	if err := json.Unmarshal(escrowBytes, &escrow); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal escrow: %v", err)
+	}
+	return &escrow, nil
+}
+
+func putEscrow(ctx kalpsdk.TransactionContextInterface, escrow *Escrow) error {
+	key, err := ctx.CreateCompositeKey(escrowObjectType, []string{escrow.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create escrow key: %v", err)
+	}
+	escrowBytes, err := json.Marshal(escrow)
+	if err != nil {
+		return fmt.Errorf("failed to marshal escrow: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, escrowBytes); err != nil {
+		return fmt.Errorf("failed to store escrow: %v", err)
+	}
+	return nil
+}
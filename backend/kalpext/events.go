@@ -0,0 +1,75 @@
+package kalpext
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+const (
+	// EnvelopeEventName is the chaincode event name used when a transaction
+	// raises more than one event.
+	EnvelopeEventName = "Events"
+
+	// EnvelopeVersion is the version of the Envelope schema.
+	EnvelopeVersion = 1
+)
+
+// Event is one logical event raised by a transaction.
+type Event struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Envelope is the payload of an EnvelopeEventName event. It holds every
+// event raised by the transaction in the order they were raised.
+type Envelope struct {
+	Version int     `json:"version"`
+	Events  []Event `json:"events"`
+}
+
+// EventBuffer collects the events of one transaction. Fabric keeps only the
+// last event a transaction sets, so the buffer sets a single chaincode event
+// after every Emit: the event itself while there is only one, and an Envelope
+// of all of them once there are more. Create one buffer per transaction and
+// pass it to everything that raises events.
+type EventBuffer struct {
+	ctx    kalpsdk.TransactionContextInterface
+	events []Event
+}
+
+// NewEventBuffer returns an empty buffer for the transaction of ctx.
+func NewEventBuffer(ctx kalpsdk.TransactionContextInterface) *EventBuffer {
+	return &EventBuffer{ctx: ctx}
+}
+
+// Emit marshals payload to JSON and raises it as the event name.
+func (b *EventBuffer) Emit(name string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %v", name, err)
+	}
+	b.events = append(b.events, Event{Name: name, Payload: payloadBytes})
+
+	if len(b.events) == 1 {
+		return b.setEvent(name, payloadBytes)
+	}
+	envelopeBytes, err := json.Marshal(Envelope{Version: EnvelopeVersion, Events: b.events})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event envelope: %v", err)
+	}
+	return b.setEvent(EnvelopeEventName, envelopeBytes)
+}
+
+// Events returns the events raised so far.
+func (b *EventBuffer) Events() []Event {
+	return b.events
+}
+
+func (b *EventBuffer) setEvent(name string, payload []byte) error {
+	if err := b.ctx.SetEvent(name, payload); err != nil {
+		return fmt.Errorf("failed to set %s event: %v", name, err)
+	}
+	return nil
+}
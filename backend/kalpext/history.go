@@ -0,0 +1,68 @@
+// Package kalpext holds helpers for contracts built on kalpsdk that the SDK
+// itself does not provide. Everything here works through
+// kalpsdk.TransactionContextInterface, so it runs the same on a peer and in
+// ledgersim.
+package kalpext
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// HistoryEntry is one committed modification of a key with its value decoded
+// into T. Value is the zero T when the modification is a delete.
+type HistoryEntry[T any] struct {
+	TxID      string    `json:"txId"`
+	Value     T         `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+	IsDelete  bool      `json:"isDelete"`
+}
+
+// DecodeJSON unmarshals a JSON value into T. It is the usual decode function
+// for GetHistory and ReadHistory.
+func DecodeJSON[T any](value []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(value, &v)
+	return v, err
+}
+
+// GetHistory returns up to limit modifications of key, newest first, with
+// each value decoded by decode. A limit of zero or less returns them all.
+func GetHistory[T any](ctx kalpsdk.TransactionContextInterface, key string, limit int, decode func([]byte) (T, error)) ([]HistoryEntry[T], error) {
+	iterator, err := ctx.GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %v", key, err)
+	}
+	defer iterator.Close()
+	return ReadHistory(iterator, limit, decode)
+}
+
+// ReadHistory drains up to limit modifications from iterator, decoding each
+// value with decode. Fabric returns a key's history newest first, and the
+// order is kept. A limit of zero or less reads the whole iterator.
+func ReadHistory[T any](iterator kalpsdk.HistoryQueryIteratorInterface, limit int, decode func([]byte) (T, error)) ([]HistoryEntry[T], error) {
+	entries := []HistoryEntry[T]{}
+	for iterator.HasNext() && (limit <= 0 || len(entries) < limit) {
+		modification, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %v", err)
+		}
+		entry := HistoryEntry[T]{
+			TxID:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}
+		if modification.Timestamp != nil {
+			entry.Timestamp = modification.Timestamp.AsTime().UTC()
+		}
+		if !modification.IsDelete {
+			if entry.Value, err = decode(modification.Value); err != nil {
+				return nil, fmt.Errorf("failed to decode value of tx %s: %v", modification.TxId, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
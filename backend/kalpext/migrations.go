@@ -0,0 +1,282 @@
+package kalpext
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// SchemaVersionKey holds the schema version of a contract's stored records
+// and the progress of a running migration.
+const SchemaVersionKey = "schemaVersion"
+
+// Migration is one step from the previous schema version to Version. It
+// upgrades the plain Keys and every key of the composite ObjectTypes.
+type Migration struct {
+	Version     int
+	Description string
+	Keys        []string
+	ObjectTypes []string
+
+	// Upgrade returns value in the format of Version, or nil when it
+	// already is in that format. It must accept values in either format,
+	// because records written after a step started are already upgraded
+	// and reads call it for records the step has not reached yet.
+	Upgrade func(value []byte) ([]byte, error)
+}
+
+// Migrations is a contract's registry of migration steps, ordered by
+// Version starting at 1 without gaps.
+type Migrations []Migration
+
+// MigrationStatus reports the schema version and the progress of the next
+// pending step. Bookmark is the last key the step upgraded and Source the
+// index of the key or object type it is working on, counting Keys first.
+type MigrationStatus struct {
+	Version       int    `json:"version"`
+	LatestVersion int    `json:"latestVersion"`
+	Pending       string `json:"pending,omitempty" metadata:",optional"`
+	Source        int    `json:"source"`
+	Bookmark      string `json:"bookmark,omitempty" metadata:",optional"`
+	Processed     int    `json:"processed"`
+	Done          bool   `json:"done"`
+}
+
+// migrationProgress is the value stored under SchemaVersionKey.
+type migrationProgress struct {
+	Version  int    `json:"version"`
+	Source   int    `json:"source,omitempty"`
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+// Latest returns the schema version the last step migrates to.
+func (m Migrations) Latest() int {
+	return len(m)
+}
+
+// Setup records the latest schema version while initializing a contract,
+// so that a fresh ledger needs no migration. When any key or object type of
+// a step already holds records, or a version is recorded, it leaves the
+// version as it is, so that Migrate upgrades those records.
+func (m Migrations) Setup(ctx kalpsdk.TransactionContextInterface) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	progressBytes, err := ctx.GetState(SchemaVersionKey)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if progressBytes != nil {
+		return nil
+	}
+	for _, step := range m {
+		for _, key := range step.Keys {
+			value, err := ctx.GetState(key)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", key, err)
+			}
+			if value != nil {
+				return nil
+			}
+		}
+		for _, objectType := range step.ObjectTypes {
+			found, err := hasObjectType(ctx, objectType)
+			if err != nil || found {
+				return err
+			}
+		}
+	}
+	return putMigrationProgress(ctx, migrationProgress{Version: m.Latest()})
+}
+
+// Status returns the schema version and migration progress.
+func (m Migrations) Status(ctx kalpsdk.TransactionContextInterface) (*MigrationStatus, error) {
+	progress, err := getMigrationProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(progress, 0), nil
+}
+
+// Migrate runs the pending steps, reading at most batchSize records. It
+// records how far it got, so calling it again continues where it stopped,
+// until the returned status is Done.
+func (m Migrations) Migrate(ctx kalpsdk.TransactionContextInterface, batchSize int) (*MigrationStatus, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+	progress, err := getMigrationProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if progress.Version > m.Latest() {
+		return nil, fmt.Errorf("schema version %d is newer than the latest migration %d", progress.Version, m.Latest())
+	}
+	initial := progress
+	processed := 0
+	for progress.Version < m.Latest() {
+		step := m[progress.Version]
+		sources := len(step.Keys) + len(step.ObjectTypes)
+		for progress.Source < sources {
+			if processed == batchSize {
+				if err := putMigrationProgress(ctx, progress); err != nil {
+					return nil, err
+				}
+				return m.status(progress, processed), nil
+			}
+			var done bool
+			if progress.Source < len(step.Keys) {
+				err = upgradeKey(ctx, step, step.Keys[progress.Source])
+				processed++
+				done = true
+			} else {
+				objectType := step.ObjectTypes[progress.Source-len(step.Keys)]
+				var n int
+				n, done, err = upgradeObjectType(ctx, step, objectType, &progress.Bookmark, batchSize-processed)
+				processed += n
+			}
+			if err != nil {
+				return nil, fmt.Errorf("migration to version %d failed: %v", step.Version, err)
+			}
+			if !done {
+				break
+			}
+			progress.Source++
+			progress.Bookmark = ""
+		}
+		if progress.Source < sources {
+			break
+		}
+		progress = migrationProgress{Version: step.Version}
+	}
+	if progress != initial {
+		if err := putMigrationProgress(ctx, progress); err != nil {
+			return nil, err
+		}
+	}
+	return m.status(progress, processed), nil
+}
+
+func (m Migrations) status(progress migrationProgress, processed int) *MigrationStatus {
+	status := &MigrationStatus{
+		Version:       progress.Version,
+		LatestVersion: m.Latest(),
+		Source:        progress.Source,
+		Bookmark:      progress.Bookmark,
+		Processed:     processed,
+		Done:          progress.Version >= m.Latest(),
+	}
+	if !status.Done {
+		status.Pending = m[progress.Version].Description
+	}
+	return status
+}
+
+func (m Migrations) validate() error {
+	for i, step := range m {
+		if step.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", step.Description, step.Version, i+1)
+		}
+		if step.Upgrade == nil {
+			return fmt.Errorf("migration %q has no upgrade function", step.Description)
+		}
+	}
+	return nil
+}
+
+// hasObjectType reports whether any composite key of objectType exists.
+func hasObjectType(ctx kalpsdk.TransactionContextInterface, objectType string) (bool, error) {
+	iterator, err := ctx.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s keys: %v", objectType, err)
+	}
+	defer iterator.Close()
+	return iterator.HasNext(), nil
+}
+
+func upgradeKey(ctx kalpsdk.TransactionContextInterface, step Migration, key string) error {
+	value, err := ctx.GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	if value == nil {
+		return nil
+	}
+	return upgradeValue(ctx, step, key, value)
+}
+
+// upgradeObjectType upgrades up to limit keys of objectType after bookmark
+// and advances bookmark. It reports whether it reached the last key.
+func upgradeObjectType(ctx kalpsdk.TransactionContextInterface, step Migration, objectType string, bookmark *string, limit int) (int, bool, error) {
+	// Paginated queries are not allowed in transactions that write, so the
+	// keys up to the bookmark are skipped instead.
+	iterator, err := ctx.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read %s keys: %v", objectType, err)
+	}
+	defer iterator.Close()
+
+	n := 0
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return n, false, fmt.Errorf("failed to read %s keys: %v", objectType, err)
+		}
+		if kv.Key <= *bookmark {
+			continue
+		}
+		if n == limit {
+			return n, false, nil
+		}
+		if err := upgradeValue(ctx, step, kv.Key, kv.Value); err != nil {
+			return n, false, err
+		}
+		*bookmark = kv.Key
+		n++
+	}
+	return n, true, nil
+}
+
+func upgradeValue(ctx kalpsdk.TransactionContextInterface, step Migration, key string, value []byte) error {
+	upgraded, err := step.Upgrade(value)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade %q: %v", key, err)
+	}
+	if upgraded == nil {
+		return nil
+	}
+	if err := ctx.PutStateWithoutKYC(key, upgraded); err != nil {
+		return fmt.Errorf("failed to store %q: %v", key, err)
+	}
+	return nil
+}
+
+func getMigrationProgress(ctx kalpsdk.TransactionContextInterface) (migrationProgress, error) {
+	var progress migrationProgress
+	progressBytes, err := ctx.GetState(SchemaVersionKey)
+	if err != nil {
+		return progress, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if progressBytes == nil {
+		return progress, nil
+	}
+	if err := json.Unmarshal(progressBytes, &progress); err != nil {
+		return progress, fmt.Errorf("failed to unmarshal schema version: %v", err)
+	}
+	return progress, nil
+}
+
+func putMigrationProgress(ctx kalpsdk.TransactionContextInterface, progress migrationProgress) error {
+	progressBytes, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema version: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(SchemaVersionKey, progressBytes); err != nil {
+		return fmt.Errorf("failed to store schema version: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package kalpext
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// stubContext is implemented by kalpsdk.TransactionContext. The paginated
+// queries are on the stub only, not on kalpsdk.TransactionContextInterface.
+type stubContext interface {
+	GetStub() shim.ChaincodeStubInterface
+}
+
+// PartialCompositeKeyPage returns an iterator over up to pageSize states
+// whose composite keys start with objectType and keys. With after set, the
+// page starts at the first key whose next attribute sorts after it, so the
+// peer seeks there instead of reading the keys before it. more reports
+// whether keys follow the page. Fabric runs paginated queries only in
+// transactions that write nothing.
+func PartialCompositeKeyPage(ctx kalpsdk.TransactionContextInterface, objectType string, keys []string, after string, pageSize int) (iterator kalpsdk.StateQueryIteratorInterface, more bool, err error) {
+	stubCtx, ok := ctx.(stubContext)
+	if !ok {
+		return nil, false, fmt.Errorf("transaction context does not support paginated queries")
+	}
+	start := ""
+	if after != "" {
+		key, err := ctx.CreateCompositeKey(objectType, append(append([]string{}, keys...), after))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create bookmark key: %v", err)
+		}
+		// Attributes cannot hold U+0000, so no key lies between key and
+		// key+"\x00".
+		start = key + "\x00"
+	}
+	pageIterator, metadata, err := stubCtx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, keys, int32(pageSize), start)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s page: %v", objectType, err)
+	}
+	return pageIterator, metadata != nil && metadata.Bookmark != "", nil
+}
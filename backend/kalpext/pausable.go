@@ -0,0 +1,115 @@
+package kalpext
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// PausedKey holds the paused flag of a pausable contract.
+const PausedKey = "paused"
+
+// ErrPaused is returned by every state write while the contract is paused.
+var ErrPaused = errors.New("contract is paused")
+
+// PausableTransactionContext is a transaction context that rejects every
+// PutState and DelState call with ErrPaused while the contract is paused.
+// Reads are unaffected. Set it as the contract's TransactionContextHandler:
+//
+//	contract.TransactionContextHandler = new(kalpext.PausableTransactionContext)
+type PausableTransactionContext struct {
+	kalpsdk.TransactionContext
+
+	checked bool
+	paused  bool
+}
+
+func (ctx *PausableTransactionContext) PutStateWithKYC(key string, value []byte) error {
+	if err := ctx.checkWritable(key); err != nil {
+		return err
+	}
+	return ctx.TransactionContext.PutStateWithKYC(key, value)
+}
+
+func (ctx *PausableTransactionContext) PutStateWithoutKYC(key string, value []byte) error {
+	if err := ctx.checkWritable(key); err != nil {
+		return err
+	}
+	return ctx.TransactionContext.PutStateWithoutKYC(key, value)
+}
+
+func (ctx *PausableTransactionContext) DelStateWithKYC(key string) error {
+	if err := ctx.checkWritable(key); err != nil {
+		return err
+	}
+	return ctx.TransactionContext.DelStateWithKYC(key)
+}
+
+func (ctx *PausableTransactionContext) DelStateWithoutKYC(key string) error {
+	if err := ctx.checkWritable(key); err != nil {
+		return err
+	}
+	return ctx.TransactionContext.DelStateWithoutKYC(key)
+}
+
+// checkWritable returns ErrPaused when the contract is paused, except for the
+// paused flag itself so that the contract can be unpaused. The flag is read
+// once per transaction; reading it also puts it in the read set, so writes
+// endorsed before a Pause fail validation once the Pause commits.
+func (ctx *PausableTransactionContext) checkWritable(key string) error {
+	if key == PausedKey {
+		return nil
+	}
+	if !ctx.checked {
+		paused, err := IsPaused(ctx)
+		if err != nil {
+			return err
+		}
+		ctx.paused = paused
+		ctx.checked = true
+	}
+	if ctx.paused {
+		return ErrPaused
+	}
+	return nil
+}
+
+// IsPaused reports whether the contract is paused.
+func IsPaused(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+	paused, err := ctx.GetState(PausedKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read paused flag: %v", err)
+	}
+	return paused != nil, nil
+}
+
+// Pause stops all state writes until Unpause.
+func Pause(ctx kalpsdk.TransactionContextInterface) error {
+	paused, err := IsPaused(ctx)
+	if err != nil {
+		return err
+	}
+	if paused {
+		return ErrPaused
+	}
+	if err := ctx.PutStateWithoutKYC(PausedKey, []byte("true")); err != nil {
+		return fmt.Errorf("failed to pause: %v", err)
+	}
+	return nil
+}
+
+// Unpause allows state writes again.
+func Unpause(ctx kalpsdk.TransactionContextInterface) error {
+	paused, err := IsPaused(ctx)
+	if err != nil {
+		return err
+	}
+	if !paused {
+		return fmt.Errorf("contract is not paused")
+	}
+	if err := ctx.DelStateWithoutKYC(PausedKey); err != nil {
+		return fmt.Errorf("failed to unpause: %v", err)
+	}
+	return nil
+}
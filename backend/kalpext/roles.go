@@ -0,0 +1,192 @@
+package kalpext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+const (
+	// DefaultAdminRole administers every role without an admin role of its
+	// own, including itself.
+	DefaultAdminRole = "DEFAULT_ADMIN"
+
+	// RoleObjectType is the composite key type of role members, keyed by
+	// role and user ID.
+	RoleObjectType = "role"
+
+	// RoleAdminObjectType is the composite key type holding the admin role
+	// of a role, keyed by role.
+	RoleAdminObjectType = "roleAdmin"
+)
+
+// HasRole reports whether userID has role.
+func HasRole(ctx kalpsdk.TransactionContextInterface, role, userID string) (bool, error) {
+	key, err := ctx.CreateCompositeKey(RoleObjectType, []string{role, userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to create role key: %v", err)
+	}
+	member, err := ctx.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read role %s: %v", role, err)
+	}
+	return member != nil, nil
+}
+
+// RequireRole returns an error unless the client has role.
+func RequireRole(ctx kalpsdk.TransactionContextInterface, role string) error {
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return fmt.Errorf("failed to get client id: %v", err)
+	}
+	ok, err := HasRole(ctx, role, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user %s is missing role %s", userID, role)
+	}
+	return nil
+}
+
+// GetRoleAdmin returns the role whose members can grant and revoke role.
+func GetRoleAdmin(ctx kalpsdk.TransactionContextInterface, role string) (string, error) {
+	key, err := ctx.CreateCompositeKey(RoleAdminObjectType, []string{role})
+	if err != nil {
+		return "", fmt.Errorf("failed to create role admin key: %v", err)
+	}
+	adminRole, err := ctx.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read admin role of %s: %v", role, err)
+	}
+	if adminRole == nil {
+		return DefaultAdminRole, nil
+	}
+	return string(adminRole), nil
+}
+
+// SetRoleAdmin makes adminRole the admin role of role. The client must have
+// the current admin role of role.
+func SetRoleAdmin(ctx kalpsdk.TransactionContextInterface, role, adminRole string) error {
+	if err := requireRoleAdmin(ctx, role); err != nil {
+		return err
+	}
+	if err := validateRole(adminRole); err != nil {
+		return err
+	}
+	key, err := ctx.CreateCompositeKey(RoleAdminObjectType, []string{role})
+	if err != nil {
+		return fmt.Errorf("failed to create role admin key: %v", err)
+	}
+	if adminRole == DefaultAdminRole {
+		return ctx.DelStateWithoutKYC(key)
+	}
+	return ctx.PutStateWithoutKYC(key, []byte(adminRole))
+}
+
+// GrantRole gives role to userID. The client must have the admin role of
+// role.
+func GrantRole(ctx kalpsdk.TransactionContextInterface, role, userID string) error {
+	if err := requireRoleAdmin(ctx, role); err != nil {
+		return err
+	}
+	return SetupRole(ctx, role, userID)
+}
+
+// RevokeRole takes role away from userID. The client must have the admin role
+// of role.
+func RevokeRole(ctx kalpsdk.TransactionContextInterface, role, userID string) error {
+	if err := requireRoleAdmin(ctx, role); err != nil {
+		return err
+	}
+	return removeRole(ctx, role, userID)
+}
+
+// RenounceRole takes role away from the client.
+func RenounceRole(ctx kalpsdk.TransactionContextInterface, role string) error {
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return fmt.Errorf("failed to get client id: %v", err)
+	}
+	return removeRole(ctx, role, userID)
+}
+
+// SetupRole gives role to userID without checking the client, for use while
+// initializing a contract.
+func SetupRole(ctx kalpsdk.TransactionContextInterface, role, userID string) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	if userID == "" {
+		return fmt.Errorf("user id must not be empty")
+	}
+	key, err := ctx.CreateCompositeKey(RoleObjectType, []string{role, userID})
+	if err != nil {
+		return fmt.Errorf("failed to create role key: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, []byte(userID)); err != nil {
+		return fmt.Errorf("failed to grant role %s: %v", role, err)
+	}
+	return nil
+}
+
+func removeRole(ctx kalpsdk.TransactionContextInterface, role, userID string) error {
+	ok, err := HasRole(ctx, role, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user %s does not have role %s", userID, role)
+	}
+	key, err := ctx.CreateCompositeKey(RoleObjectType, []string{role, userID})
+	if err != nil {
+		return fmt.Errorf("failed to create role key: %v", err)
+	}
+	if err := ctx.DelStateWithoutKYC(key); err != nil {
+		return fmt.Errorf("failed to revoke role %s: %v", role, err)
+	}
+	return nil
+}
+
+func requireRoleAdmin(ctx kalpsdk.TransactionContextInterface, role string) error {
+	adminRole, err := GetRoleAdmin(ctx, role)
+	if err != nil {
+		return err
+	}
+	return RequireRole(ctx, adminRole)
+}
+
+func validateRole(role string) error {
+	if role == "" {
+		return fmt.Errorf("role must not be empty")
+	}
+	return nil
+}
+
+// RoleRequirements maps transaction names to the role a client needs to
+// submit them. Set its Check method as the contract's BeforeTransaction to
+// enforce it for every transaction:
+//
+//	contract.BeforeTransaction = kalpext.RoleRequirements{
+//		"ImportState": kalpext.DefaultAdminRole,
+//	}.Check
+type RoleRequirements map[string]string
+
+// Check returns an error when the transaction of ctx requires a role the
+// client does not have.
+func (r RoleRequirements) Check(ctx kalpsdk.TransactionContextInterface) error {
+	fn, _ := ctx.GetFunctionAndParameters()
+	// Transactions of a named contract arrive as "Contract:Function".
+	if i := strings.LastIndex(fn, ":"); i >= 0 {
+		fn = fn[i+1:]
+	}
+	role, ok := r[fn]
+	if !ok {
+		return nil
+	}
+	if err := RequireRole(ctx, role); err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	return nil
+}
@@ -1,33 +1,319 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
 )
 
 type SmartContract struct {
 	kalpsdk.Contract
 }
 
-func (s *SmartContract) Init(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+const (
+	// latestGreetingKey holds the most recently set greeting.
+	latestGreetingKey = "greeting"
+
+	// greetingObjectType is the composite key type of per-user greetings,
+	// keyed by the author's user ID.
+	greetingObjectType = "greeting"
+
+	// maxGreetingPageSize caps the greetings returned by ListGreetings and
+	// GetGreetingHistory.
+	maxGreetingPageSize = 100
+)
+
+// Greeting is a greeting together with who set it and when.
+type Greeting struct {
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GreetingChangedEventName is the name of the event SetGreeting,
+// SetLocalizedGreeting and ScheduleGreeting raise.
+const GreetingChangedEventName = "GreetingChanged"
+
+// greetingChangedSchemaVersion is the version of the GreetingChangedEvent
+// payload, described by schemas/greeting-changed.v1.json. Fields are only
+// ever added within a version; anything else bumps it.
+const greetingChangedSchemaVersion = 1
+
+// GreetingChangedEvent is the payload of a GreetingChanged event. Old is
+// empty when there was no greeting before. Locale is set when a localized
+// greeting changed, and ValidFrom and ValidUntil when a greeting was
+// scheduled.
+type GreetingChangedEvent struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Old           string    `json:"old"`
+	New           string    `json:"new"`
+	Author        string    `json:"author"`
+	TxID          string    `json:"txId"`
+	Timestamp     time.Time `json:"timestamp"`
+	Locale        string    `json:"locale,omitempty" metadata:",optional"`
+	ValidFrom     string    `json:"validFrom,omitempty" metadata:",optional"`
+	ValidUntil    string    `json:"validUntil,omitempty" metadata:",optional"`
+}
+
+// GreetingHistoryEntry is one change of the latest greeting. Greeting is
+// absent when the change deleted it.
+type GreetingHistoryEntry struct {
+	Greeting  *Greeting `json:"greeting,omitempty" metadata:",optional"`
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	IsDelete  bool      `json:"isDelete"`
+}
+
+// GreetingPage is one page of ListGreetings. Bookmark is passed to the next
+// call and is empty on the last page.
+type GreetingPage struct {
+	Greetings []Greeting `json:"greetings"`
+	Bookmark  string     `json:"bookmark"`
+}
+
+// Init records the client as owner and admin and stores config.
+func (s *SmartContract) Init(ctx kalpsdk.TransactionContextInterface, config Config) (bool, error) {
+	ownership, err := getOwnership(ctx)
+	if err != nil {
+		return false, err
+	}
+	if ownership != nil {
+		return false, fmt.Errorf("contract is already initialized")
+	}
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return false, fmt.Errorf("failed to get client id: %v", err)
+	}
+	if err := putOwnership(ctx, &Ownership{Owner: userID}); err != nil {
+		return false, err
+	}
+	if err := kalpext.SetupRole(ctx, kalpext.DefaultAdminRole, userID); err != nil {
+		return false, err
+	}
+	if err := putConfig(ctx, &config); err != nil {
+		return false, err
+	}
+	if err := migrations.Setup(ctx); err != nil {
+		return false, err
+	}
 	s.Logger.Info("Greeting Smart Contract initialized")
 	return true, nil
 }
 
 func (s *SmartContract) SetGreeting(ctx kalpsdk.TransactionContextInterface, greeting string) error {
 	s.Logger.Info("Setting greeting")
-	return ctx.PutStateWithoutKYC("greeting", []byte(greeting))
+	policy, err := s.GetPaymentPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if policy.Required {
+		return fmt.Errorf("greetings require a payment, use SetPaidGreeting")
+	}
+	_, err = s.setGreeting(ctx, greeting)
+	return err
+}
+
+// setGreeting validates greeting and stores it as the client's and the latest
+// greeting.
+func (s *SmartContract) setGreeting(ctx kalpsdk.TransactionContextInterface, greeting string) (Greeting, error) {
+	greeting, err := s.validateGreeting(ctx, greeting)
+	if err != nil {
+		return Greeting{}, err
+	}
+	record, err := newGreeting(ctx, greeting)
+	if err != nil {
+		return Greeting{}, err
+	}
+	put, err := greetingWriter(ctx)
+	if err != nil {
+		return Greeting{}, err
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return Greeting{}, fmt.Errorf("failed to marshal greeting: %v", err)
+	}
+
+	oldBytes, err := ctx.GetState(latestGreetingKey)
+	if err != nil {
+		return Greeting{}, fmt.Errorf("failed to read greeting: %v", err)
+	}
+	oldText := ""
+	if oldBytes != nil {
+		old, err := decodeGreeting(oldBytes)
+		if err != nil {
+			return Greeting{}, err
+		}
+		oldText = old.Text
+	}
+
+	userKey, err := ctx.CreateCompositeKey(greetingObjectType, []string{record.Author})
+	if err != nil {
+		return Greeting{}, fmt.Errorf("failed to create greeting key: %v", err)
+	}
+	if err := put(userKey, recordBytes); err != nil {
+		return Greeting{}, fmt.Errorf("failed to store greeting: %v", err)
+	}
+	if err := put(latestGreetingKey, recordBytes); err != nil {
+		return Greeting{}, fmt.Errorf("failed to store greeting: %v", err)
+	}
+
+	return record, emitGreetingChanged(ctx, oldText, record, "")
 }
 
+// GetGreeting returns the scheduled greeting active at the transaction
+// timestamp, or the latest greeting when none is.
 func (s *SmartContract) GetGreeting(ctx kalpsdk.TransactionContextInterface) (string, error) {
 	s.Logger.Info("Getting greeting")
-	greetingBytes, err := ctx.GetState("greeting")
+	scheduled, err := activeScheduledGreeting(ctx)
+	if err != nil {
+		return "", err
+	}
+	if scheduled != nil {
+		return scheduled.Text, nil
+	}
+	greeting, err := s.GetLatestGreeting(ctx)
+	if err != nil {
+		return "", err
+	}
+	return greeting.Text, nil
+}
+
+// GetLatestGreeting returns the most recently set greeting with its author.
+func (s *SmartContract) GetLatestGreeting(ctx kalpsdk.TransactionContextInterface) (*Greeting, error) {
+	greetingBytes, err := ctx.GetState(latestGreetingKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to read greeting: %v", err)
+		return nil, fmt.Errorf("failed to read greeting: %v", err)
 	}
 	if greetingBytes == nil {
-		return "", fmt.Errorf("greeting not found")
+		return nil, fmt.Errorf("greeting not found")
+	}
+	return decodeGreeting(greetingBytes)
+}
+
+// GetUserGreeting returns the greeting last set by userID.
+func (s *SmartContract) GetUserGreeting(ctx kalpsdk.TransactionContextInterface, userID string) (*Greeting, error) {
+	userKey, err := ctx.CreateCompositeKey(greetingObjectType, []string{userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create greeting key: %v", err)
+	}
+	greetingBytes, err := ctx.GetState(userKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read greeting: %v", err)
+	}
+	if greetingBytes == nil {
+		return nil, fmt.Errorf("greeting for user %s not found", userID)
+	}
+	return decodeGreeting(greetingBytes)
+}
+
+// ListGreetings returns up to pageSize user greetings ordered by user ID,
+// starting after the user ID in bookmark.
+func (s *SmartContract) ListGreetings(ctx kalpsdk.TransactionContextInterface, bookmark string, pageSize int) (*GreetingPage, error) {
+	if pageSize <= 0 || pageSize > maxGreetingPageSize {
+		pageSize = maxGreetingPageSize
+	}
+	iterator, more, err := kalpext.PartialCompositeKeyPage(ctx, greetingObjectType, []string{}, bookmark, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	page := &GreetingPage{Greetings: []Greeting{}}
+	lastUserID := ""
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read greetings: %v", err)
+		}
+		_, attributes, err := ctx.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 1 {
+			return nil, fmt.Errorf("invalid greeting key %q", kv.Key)
+		}
+		greeting, err := decodeGreeting(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		page.Greetings = append(page.Greetings, *greeting)
+		lastUserID = attributes[0]
+	}
+	if more {
+		page.Bookmark = lastUserID
+	}
+	return page, nil
+}
+
+// GetGreetingHistory returns up to limit changes of the latest greeting,
+// newest first.
+func (s *SmartContract) GetGreetingHistory(ctx kalpsdk.TransactionContextInterface, limit int) ([]GreetingHistoryEntry, error) {
+	if limit <= 0 || limit > maxGreetingPageSize {
+		limit = maxGreetingPageSize
+	}
+	entries, err := kalpext.GetHistory(ctx, latestGreetingKey, limit, decodeGreeting)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]GreetingHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, GreetingHistoryEntry{
+			Greeting:  entry.Value,
+			TxID:      entry.TxID,
+			Timestamp: entry.Timestamp,
+			IsDelete:  entry.IsDelete,
+		})
+	}
+	return history, nil
+}
+
+func emitGreetingChanged(ctx kalpsdk.TransactionContextInterface, oldText string, record Greeting, locale string) error {
+	events := kalpext.NewEventBuffer(ctx)
+	return events.Emit(GreetingChangedEventName, GreetingChangedEvent{
+		SchemaVersion: greetingChangedSchemaVersion,
+		Old:           oldText,
+		New:           record.Text,
+		Author:        record.Author,
+		TxID:          record.TxID,
+		Timestamp:     record.Timestamp,
+		Locale:        locale,
+	})
+}
+
+// newGreeting returns a greeting record for text, written by the client in
+// the current transaction.
+func newGreeting(ctx kalpsdk.TransactionContextInterface, text string) (Greeting, error) {
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return Greeting{}, fmt.Errorf("failed to get client id: %v", err)
+	}
+	timestamp, err := ctx.GetTxTimestamp()
+	if err != nil {
+		return Greeting{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return Greeting{
+		Text:      text,
+		Author:    userID,
+		TxID:      ctx.GetTxID(),
+		Timestamp: timestamp.AsTime().UTC(),
+	}, nil
+}
+
+// decodeGreeting parses a stored greeting, upgrading values Migrate has not
+// reached yet.
+func decodeGreeting(greetingBytes []byte) (*Greeting, error) {
+	upgraded, err := upgradeGreeting(greetingBytes)
+	if err != nil {
+		return nil, err
+	}
+	if upgraded != nil {
+		greetingBytes = upgraded
+	}
+	var greeting Greeting
+	if err := json.Unmarshal(greetingBytes, &greeting); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal greeting: %v", err)
 	}
-	return string(greetingBytes), nil
+	return &greeting, nil
 }
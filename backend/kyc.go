@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// configKey holds the contract's Config, recorded by Init.
+const configKey = "config"
+
+// Write modes of Config.
+const (
+	// WriteModeOpen lets anyone set greetings.
+	WriteModeOpen = "open"
+
+	// WriteModeKYC lets only clients that completed KYC set greetings.
+	WriteModeKYC = "kyc"
+)
+
+// KYCRequiredCode starts the message of a KYCRequiredError, so that clients
+// can tell it apart from other errors returned through the gateway.
+const KYCRequiredCode = "KYC_REQUIRED"
+
+// Config is the contract configuration passed to Init. An empty WriteMode
+// is WriteModeOpen.
+type Config struct {
+	WriteMode string `json:"writeMode"`
+}
+
+// KYCRequiredError is returned for a greeting set in KYC mode by a client
+// that has not completed KYC.
+type KYCRequiredError struct {
+	UserID string
+}
+
+func (e *KYCRequiredError) Error() string {
+	return fmt.Sprintf("%s: user %s must complete KYC before setting a greeting", KYCRequiredCode, e.UserID)
+}
+
+// GetConfig returns the contract configuration.
+func (s *SmartContract) GetConfig(ctx kalpsdk.TransactionContextInterface) (*Config, error) {
+	return getConfig(ctx)
+}
+
+// SetWriteMode switches between WriteModeOpen and WriteModeKYC.
+func (s *SmartContract) SetWriteMode(ctx kalpsdk.TransactionContextInterface, writeMode string) error {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+	config.WriteMode = writeMode
+	return putConfig(ctx, config)
+}
+
+func getConfig(ctx kalpsdk.TransactionContextInterface) (*Config, error) {
+	configBytes, err := ctx.GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if configBytes == nil {
+		return &Config{WriteMode: WriteModeOpen}, nil
+	}
+	var config Config
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+	return &config, nil
+}
+
+func putConfig(ctx kalpsdk.TransactionContextInterface, config *Config) error {
+	switch config.WriteMode {
+	case "":
+		config.WriteMode = WriteModeOpen
+	case WriteModeOpen, WriteModeKYC:
+	default:
+		return fmt.Errorf("invalid write mode %q, expected %q or %q", config.WriteMode, WriteModeOpen, WriteModeKYC)
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(configKey, configBytes); err != nil {
+		return fmt.Errorf("failed to store config: %v", err)
+	}
+	return nil
+}
+
+// greetingWriter returns the SDK call greetings are written with under the
+// write mode. In KYC mode it first returns a KYCRequiredError for clients
+// without KYC, which the SDK call would only report as a plain error.
+func greetingWriter(ctx kalpsdk.TransactionContextInterface) (func(key string, value []byte) error, error) {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config.WriteMode != WriteModeKYC {
+		return ctx.PutStateWithoutKYC, nil
+	}
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client id: %v", err)
+	}
+	kyc, err := ctx.GetKYC(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check KYC of user %s: %v", userID, err)
+	}
+	if !kyc {
+		return nil, &KYCRequiredError{UserID: userID}
+	}
+	return ctx.PutStateWithKYC, nil
+}
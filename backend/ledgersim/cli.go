@@ -0,0 +1,242 @@
+package ledgersim
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/snapshot"
+)
+
+const usage = `usage: %s <command> [flags] <fixture.json | snapshot.jsonl>
+
+commands:
+  determinism  endorse every transaction on two independent ledgers and
+               report differences in response, read/write set and event
+  inspect      simulate every transaction and print its read/write set,
+               range queries and event as JSON, with warnings for oversized
+               values, hot keys and phantom-read risks
+  export       write the world state to a snapshot: the ledger left by a
+               fixture, or with -contract the state of a deployed contract
+  import       load a snapshot into a deployed contract through its
+               admin-only ImportState function, in batches
+
+export and import read the gateway API key from KALP_API_KEY.
+`
+
+// Main runs the ledgersim command line for the chaincode built by newChaincode
+// and returns the process exit code. Chaincode and SDK logging is moved to
+// stderr so that command output on stdout stays machine readable.
+func Main(newChaincode func() (*kalpsdk.ContractChaincode, error), args []string) int {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	kalpsdk.NewLogger().SetChaincodeOutput(os.Stderr)
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return 2
+	}
+
+	var err error
+	var failed bool
+	switch args[0] {
+	case "determinism":
+		failed, err = runDeterminism(newChaincode, args[1:], out)
+	case "inspect":
+		err = runInspect(newChaincode, args[1:], out)
+	case "export":
+		err = runExport(newChaincode, args[1:], out)
+	case "import":
+		err = runImport(args[1:], out)
+	default:
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 2
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func runDeterminism(newChaincode func() (*kalpsdk.ContractChaincode, error), args []string, out io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("determinism", flag.ContinueOnError)
+	skew := fs.Duration("skew", 1500*time.Millisecond, "wall-clock delay between the two endorsement runs")
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	if fs.NArg() != 1 {
+		return false, fmt.Errorf("expected one fixture file")
+	}
+
+	fixture, err := LoadFixture(fs.Arg(0))
+	if err != nil {
+		return false, err
+	}
+	diffs, err := CheckDeterminism(newChaincode, fixture, *skew)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range diffs {
+		fmt.Fprintln(out, d)
+	}
+	if len(diffs) > 0 {
+		fmt.Fprintf(out, "%d divergences in %d transactions\n", len(diffs), len(fixture.Transactions))
+		return true, nil
+	}
+	fmt.Fprintf(out, "%d transactions endorsed identically\n", len(fixture.Transactions))
+	return false, nil
+}
+
+func runInspect(newChaincode func() (*kalpsdk.ContractChaincode, error), args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	maxValue := fs.Int("max-value", DefaultInspectOptions.MaxValueSize, "warn about values and event payloads larger than this many bytes")
+	hotKey := fs.Int("hot-key", DefaultInspectOptions.HotKeyWriters, "warn about keys written by at least this many transactions (0 disables)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected one fixture file")
+	}
+
+	fixture, err := LoadFixture(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	report, err := Inspect(newChaincode, fixture, InspectOptions{MaxValueSize: *maxValue, HotKeyWriters: *hotKey})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func runExport(newChaincode func() (*kalpsdk.ContractChaincode, error), args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "write the snapshot to this file instead of stdout")
+	contract := fs.String("contract", "", "export the deployed contract with this ID through the gateway")
+	gatewayURL := fs.String("gateway", DefaultGatewayURL, "gateway contract API URL")
+	pageSize := fs.Int("page", 500, "keys per ExportState call")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*contract == "") == (fs.NArg() == 0) || fs.NArg() > 1 {
+		return fmt.Errorf("expected either one fixture file or -contract")
+	}
+
+	w := bufio.NewWriter(out)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = bufio.NewWriter(file)
+	}
+
+	var count int
+	header := snapshot.Header{CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	if *contract != "" {
+		gateway, err := newGatewayFromEnv(*contract, *gatewayURL)
+		if err != nil {
+			return err
+		}
+		header.Namespace = *contract
+		sw, err := snapshot.NewWriter(w, header)
+		if err != nil {
+			return err
+		}
+		if count, err = ExportContract(gateway, sw, *pageSize); err != nil {
+			return err
+		}
+	} else {
+		fixture, err := LoadFixture(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		cc, err := newChaincode()
+		if err != nil {
+			return err
+		}
+		ledger := NewLedger()
+		if err := fixture.Seed(ledger); err != nil {
+			return err
+		}
+		n := fixture.NewNetwork(ledger)
+		for i, p := range fixture.Transactions {
+			if _, err := n.Submit(cc, p); err != nil {
+				return fmt.Errorf("transaction %d (%s): %v", i+1, p.Function, err)
+			}
+		}
+		sw, err := snapshot.NewWriter(w, header)
+		if err != nil {
+			return err
+		}
+		if err := ledger.Export(sw); err != nil {
+			return err
+		}
+		count = len(ledger.Keys())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d keys\n", count)
+	return nil
+}
+
+func runImport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	contract := fs.String("contract", "", "ID of the deployed contract to import into")
+	gatewayURL := fs.String("gateway", DefaultGatewayURL, "gateway contract API URL")
+	batch := fs.Int("batch", 100, "records per ImportState transaction")
+	skip := fs.Int("skip", 0, "skip this many records, to resume a failed import")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *contract == "" || fs.NArg() != 1 {
+		return fmt.Errorf("expected -contract and one snapshot file")
+	}
+	if *batch <= 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	gateway, err := newGatewayFromEnv(*contract, *gatewayURL)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := snapshot.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	done, err := ImportContract(gateway, r, *batch, *skip)
+	if err != nil {
+		return fmt.Errorf("%v; rerun with -skip %d to resume", err, done)
+	}
+	fmt.Fprintf(out, "imported %d records into %s\n", done-*skip, *contract)
+	return nil
+}
+
+func newGatewayFromEnv(contractID, url string) (*Gateway, error) {
+	apiKey := os.Getenv("KALP_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("KALP_API_KEY is not set")
+	}
+	g := NewGateway(contractID, apiKey)
+	g.URL = url
+	return g, nil
+}
@@ -0,0 +1,158 @@
+package ledgersim
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Divergence is a difference between two endorsements of the same proposal.
+type Divergence struct {
+	Index    int
+	Function string
+	TxID     string
+	Field    string
+	A        string
+	B        string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("transaction %d (%s, tx %.12s): %s differs\n  endorser A: %s\n  endorser B: %s", d.Index, d.Function, d.TxID, d.Field, d.A, d.B)
+}
+
+// CompareEndorsements returns the parts of two endorsements of one proposal
+// that differ: response, read set, range queries, write set and event. Reads
+// and writes are compared sorted by key, as Fabric orders them in the
+// proposal response.
+func CompareEndorsements(a, b *Result) []Divergence {
+	fields := []struct {
+		name string
+		fmt  func(*Result) string
+	}{
+		{"response status", func(r *Result) string { return fmt.Sprintf("%d %q", r.Status, r.Message) }},
+		{"response payload", func(r *Result) string { return fmt.Sprintf("%q", r.Payload) }},
+		{"read set", func(r *Result) string { return formatReads(r.RWSet.Reads) }},
+		{"range queries", func(r *Result) string { return formatRangeQueries(r.RWSet.RangeQueries) }},
+		{"write set", func(r *Result) string { return formatWrites(r.RWSet.Writes) }},
+		{"event", func(r *Result) string { return formatEvent(r.Event) }},
+	}
+
+	var diffs []Divergence
+	for _, f := range fields {
+		if fa, fb := f.fmt(a), f.fmt(b); fa != fb {
+			diffs = append(diffs, Divergence{TxID: a.TxID, Field: f.name, A: fa, B: fb})
+		}
+	}
+	return diffs
+}
+
+// CheckDeterminism submits every transaction of the fixture to two independent
+// ledgers, each served by a freshly built chaincode, and compares the two
+// endorsements of each proposal. The second run starts skew after the first
+// and with GOMAXPROCS set to 1, so code that depends on the wall clock or on
+// goroutine scheduling produces different results. Map iteration order is
+// randomised by Go on every run.
+func CheckDeterminism(newChaincode func() (*kalpsdk.ContractChaincode, error), f *Fixture, skew time.Duration) ([]Divergence, error) {
+	first, network, err := runFixture(newChaincode, f, nil, runtime.GOMAXPROCS(0))
+	if err != nil {
+		return nil, fmt.Errorf("endorser A: %v", err)
+	}
+	time.Sleep(skew)
+	second, _, err := runFixture(newChaincode, f, network, 1)
+	if err != nil {
+		return nil, fmt.Errorf("endorser B: %v", err)
+	}
+
+	var diffs []Divergence
+	for i := range first {
+		for _, d := range CompareEndorsements(first[i], second[i]) {
+			d.Index = i + 1
+			d.Function = f.Transactions[i].Function
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+func runFixture(newChaincode func() (*kalpsdk.ContractChaincode, error), f *Fixture, identities *Network, procs int) ([]*Result, *Network, error) {
+	cc, err := newChaincode()
+	if err != nil {
+		return nil, nil, err
+	}
+	ledger := NewLedger()
+	if err := f.Seed(ledger); err != nil {
+		return nil, nil, err
+	}
+	n := f.NewNetwork(ledger)
+	if identities != nil {
+		n.ShareIdentities(identities)
+	}
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+	var results []*Result
+	for i, p := range f.Transactions {
+		res, err := n.Submit(cc, p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %d (%s): %v", i+1, p.Function, err)
+		}
+		results = append(results, res)
+	}
+	return results, n, nil
+}
+
+func formatReads(reads []KVRead) string {
+	parts := make([]string, 0, len(reads))
+	for _, r := range sortedReads(reads) {
+		if r.Version == nil {
+			parts = append(parts, fmt.Sprintf("%q@absent", r.Key))
+		} else {
+			parts = append(parts, fmt.Sprintf("%q@%d:%d", r.Key, r.Version.BlockNum, r.Version.TxNum))
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func formatWrites(writes []KVWrite) string {
+	sorted := append([]KVWrite(nil), writes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	parts := make([]string, 0, len(sorted))
+	for _, w := range sorted {
+		if w.IsDelete {
+			parts = append(parts, fmt.Sprintf("%q deleted", w.Key))
+		} else {
+			parts = append(parts, fmt.Sprintf("%q=%q", w.Key, w.Value))
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func formatRangeQueries(queries []RangeQueryInfo) string {
+	parts := make([]string, 0, len(queries))
+	for _, rq := range queries {
+		// Results keep their iteration order, which is part of the query.
+		keys := make([]string, 0, len(rq.Reads))
+		for _, r := range rq.Reads {
+			keys = append(keys, fmt.Sprintf("%q", r.Key))
+		}
+		parts = append(parts, fmt.Sprintf("[%q,%q) exhausted=%t -> [%s]", rq.StartKey, rq.EndKey, rq.ItrExhausted, strings.Join(keys, " ")))
+	}
+	return "[" + strings.Join(parts, "; ") + "]"
+}
+
+func formatEvent(e *pb.ChaincodeEvent) string {
+	if e == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s %q", e.EventName, e.Payload)
+}
+
+func sortedReads(reads []KVRead) []KVRead {
+	sorted := append([]KVRead(nil), reads...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
@@ -0,0 +1,97 @@
+package ledgersim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"krc20/snapshot"
+)
+
+// Fixture is a recorded sequence of proposals, stored as JSON. The ledger is
+// loaded from Snapshot, a world-state snapshot file relative to the fixture,
+// and then seeded with State before the first proposal:
+//
+//	{
+//	  "kyc": ["alice"],
+//	  "snapshot": "testnet.jsonl",
+//	  "state": {"greeting": "Hello"},
+//	  "transactions": [
+//	    {"function": "SetGreeting", "args": ["Hello"], "user": "alice"},
+//	    {"function": "GetGreeting", "user": "bob"}
+//	  ]
+//	}
+type Fixture struct {
+	ChannelID    string            `json:"channel,omitempty"`
+	MSPID        string            `json:"mspId,omitempty"`
+	KYC          []string          `json:"kyc,omitempty"`
+	Snapshot     string            `json:"snapshot,omitempty"`
+	State        map[string]string `json:"state,omitempty"`
+	Transactions []Proposal        `json:"transactions"`
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %v", err)
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %v", path, err)
+	}
+	if len(f.Transactions) == 0 {
+		return nil, fmt.Errorf("fixture %s has no transactions", path)
+	}
+	if f.Snapshot != "" && !filepath.IsAbs(f.Snapshot) {
+		f.Snapshot = filepath.Join(filepath.Dir(path), f.Snapshot)
+	}
+	return &f, nil
+}
+
+// NewNetwork returns a network over ledger configured by the fixture.
+func (f *Fixture) NewNetwork(ledger *Ledger) *Network {
+	n := NewNetwork(ledger)
+	if f.ChannelID != "" {
+		n.ChannelID = f.ChannelID
+	}
+	if f.MSPID != "" {
+		n.MSPID = f.MSPID
+	}
+	for _, user := range f.KYC {
+		n.KYC[user] = true
+	}
+	return n
+}
+
+// Seed imports the fixture snapshot into ledger and then commits the fixture
+// state, one key per block in key order.
+func (f *Fixture) Seed(ledger *Ledger) error {
+	if f.Snapshot != "" {
+		file, err := os.Open(f.Snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to open snapshot: %v", err)
+		}
+		defer file.Close()
+		r, err := snapshot.NewReader(bufio.NewReader(file))
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Snapshot, err)
+		}
+		if _, err := ledger.Import(r); err != nil {
+			return fmt.Errorf("%s: %v", f.Snapshot, err)
+		}
+	}
+
+	keys := make([]string, 0, len(f.State))
+	for key := range f.State {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		ledger.Put("seed", key, []byte(f.State[key]))
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package ledgersim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"krc20/snapshot"
+)
+
+// DefaultGatewayURL is the Kalp gateway contract API.
+const DefaultGatewayURL = "https://gateway-api.kalp.studio/v1/contract/kalp"
+
+// Gateway calls the functions of a deployed contract through the Kalp
+// gateway, the same API the frontend uses.
+type Gateway struct {
+	URL           string
+	ContractID    string
+	APIKey        string
+	Network       string
+	WalletAddress string
+	Client        *http.Client
+}
+
+// NewGateway returns a gateway client for the contract on TESTNET.
+func NewGateway(contractID, apiKey string) *Gateway {
+	return &Gateway{
+		URL:        DefaultGatewayURL,
+		ContractID: contractID,
+		APIKey:     apiKey,
+		Network:    "TESTNET",
+		Client:     &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Query evaluates fn with the named args and decodes its result into result.
+func (g *Gateway) Query(fn string, args map[string]interface{}, result interface{}) error {
+	return g.call("query", fn, args, result)
+}
+
+// Invoke submits fn with the named args and decodes its result into result.
+func (g *Gateway) Invoke(fn string, args map[string]interface{}, result interface{}) error {
+	return g.call("invoke", fn, args, result)
+}
+
+func (g *Gateway) call(kind, fn string, args map[string]interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"network":       g.Network,
+		"blockchain":    "KALP",
+		"walletAddress": g.WalletAddress,
+		"args":          args,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s arguments: %v", fn, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s/%s/%s", g.URL, kind, g.ContractID, fn), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", g.APIKey)
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", kind, fn, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", kind, fn, err)
+	}
+
+	var reply struct {
+		Message string          `json:"message"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return fmt.Errorf("%s %s: unexpected response %q", kind, fn, data)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s failed with status %d: %s", kind, fn, resp.StatusCode, reply.Message)
+	}
+	if result == nil || len(reply.Result) == 0 {
+		return nil
+	}
+
+	// The function's return value is wrapped as {"result": value}, which
+	// the gateway sends either as an object or as a JSON-encoded string.
+	wrapped := reply.Result
+	var text string
+	if json.Unmarshal(wrapped, &text) == nil {
+		wrapped = json.RawMessage(text)
+	}
+	var inner struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(wrapped, &inner); err != nil || len(inner.Result) == 0 {
+		return fmt.Errorf("%s %s: unexpected result %s", kind, fn, reply.Result)
+	}
+	if err := json.Unmarshal(inner.Result, result); err != nil {
+		return fmt.Errorf("%s %s: failed to decode result: %v", kind, fn, err)
+	}
+	return nil
+}
+
+// ExportContract pages through the contract's ExportState function and writes
+// every key to w. It returns the number of keys written.
+func ExportContract(g *Gateway, w *snapshot.Writer, pageSize int) (int, error) {
+	count := 0
+	bookmark := ""
+	for {
+		var page struct {
+			Records  []snapshot.Record `json:"records"`
+			Bookmark string            `json:"bookmark"`
+		}
+		if err := g.Query("ExportState", map[string]interface{}{"bookmark": bookmark, "pageSize": pageSize}, &page); err != nil {
+			return count, err
+		}
+		for _, rec := range page.Records {
+			if err := w.Write(rec); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if page.Bookmark == "" {
+			return count, nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// ImportContract submits the records of r to the contract's ImportState
+// function in batches of batchSize, skipping the first skip records. On
+// failure it returns the number of records submitted before the failed
+// batch, which can be passed as skip to resume.
+func ImportContract(g *Gateway, r *snapshot.Reader, batchSize, skip int) (int, error) {
+	done := 0
+	var batch []snapshot.Record
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := g.Invoke("ImportState", map[string]interface{}{"records": batch}, nil); err != nil {
+			return fmt.Errorf("batch starting at record %d: %v", done, err)
+		}
+		done += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return done, err
+		}
+		if done < skip {
+			done++
+			continue
+		}
+		batch = append(batch, rec)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return done, err
+			}
+		}
+	}
+	return done, flush()
+}
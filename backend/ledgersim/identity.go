@@ -0,0 +1,65 @@
+package ledgersim
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+)
+
+// Identity is a simulated client identity. Its self-signed certificate has
+// the user ID as common name, which is what kalpsdk's GetUserID returns.
+type Identity struct {
+	MSPID   string
+	UserID  string
+	creator []byte
+}
+
+// NewIdentity creates an identity for userID in the given MSP.
+func NewIdentity(mspID, userID string) (*Identity, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key for %s: %v", userID, err)
+	}
+	// GetUserID cuts the common name at the first comma of the subject, so
+	// the subject needs attributes after CN.
+	name := pkix.Name{
+		CommonName:         userID,
+		OrganizationalUnit: []string{"client"},
+		Organization:       []string{mspID},
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      name,
+		Issuer:       name,
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate for %s: %v", userID, err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize identity for %s: %v", userID, err)
+	}
+	return &Identity{MSPID: mspID, UserID: userID, creator: creator}, nil
+}
+
+// Creator returns the serialized identity, as returned by GetCreator.
+func (id *Identity) Creator() []byte {
+	return id.creator
+}
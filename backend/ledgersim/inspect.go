@@ -0,0 +1,266 @@
+package ledgersim
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Warning kinds reported by Inspect.
+const (
+	WarnOversizedValue = "oversized-value"
+	WarnOversizedEvent = "oversized-event"
+	WarnHotKey         = "hot-key"
+	WarnPhantomRead    = "phantom-read-risk"
+)
+
+// InspectOptions sets the thresholds Inspect warns at.
+type InspectOptions struct {
+	// MaxValueSize is the largest value or event payload, in bytes, that
+	// is not reported.
+	MaxValueSize int
+
+	// HotKeyWriters is the number of transactions writing the same key at
+	// which the key is reported as hot.
+	HotKeyWriters int
+}
+
+// DefaultInspectOptions are the thresholds used by the inspect command.
+var DefaultInspectOptions = InspectOptions{
+	MaxValueSize:  32 * 1024,
+	HotKeyWriters: 2,
+}
+
+// Report is the outcome of inspecting a fixture.
+type Report struct {
+	Transactions []TxReport `json:"transactions"`
+	Warnings     []Warning  `json:"warnings"`
+}
+
+// TxReport describes the simulation of one proposal.
+type TxReport struct {
+	Index        int                `json:"index"`
+	Function     string             `json:"function"`
+	Args         []string           `json:"args,omitempty"`
+	User         string             `json:"user"`
+	TxID         string             `json:"txId"`
+	Timestamp    string             `json:"timestamp"`
+	Status       int32              `json:"status"`
+	Message      string             `json:"message,omitempty"`
+	Payload      string             `json:"payload,omitempty"`
+	Committed    bool               `json:"committed"`
+	Reads        []ReadReport       `json:"reads"`
+	Writes       []WriteReport      `json:"writes"`
+	RangeQueries []RangeQueryReport `json:"rangeQueries"`
+	Event        *EventReport       `json:"event,omitempty"`
+}
+
+// ReadReport is a key read by a transaction. Version is absent when the key
+// did not exist.
+type ReadReport struct {
+	Key       string        `json:"key"`
+	Composite *CompositeKey `json:"composite,omitempty"`
+	Version   *Version      `json:"version,omitempty"`
+}
+
+// WriteReport is a key written or deleted by a transaction. Values that are
+// not valid UTF-8 are given in ValueBase64 instead of Value.
+type WriteReport struct {
+	Key         string        `json:"key"`
+	Composite   *CompositeKey `json:"composite,omitempty"`
+	IsDelete    bool          `json:"isDelete,omitempty"`
+	Size        int           `json:"size"`
+	Value       string        `json:"value,omitempty"`
+	ValueBase64 string        `json:"valueBase64,omitempty"`
+}
+
+// RangeQueryReport is a range query and the keys it returned. A range query
+// in a transaction that also writes is re-executed at commit and fails the
+// transaction when another transaction has added or removed a key in the
+// range in the meantime.
+type RangeQueryReport struct {
+	StartKey        string   `json:"startKey"`
+	EndKey          string   `json:"endKey"`
+	ItrExhausted    bool     `json:"itrExhausted"`
+	Keys            []string `json:"keys"`
+	PhantomReadRisk bool     `json:"phantomReadRisk"`
+}
+
+// EventReport is the chaincode event set by a transaction.
+type EventReport struct {
+	Name          string `json:"name"`
+	Size          int    `json:"size"`
+	Payload       string `json:"payload,omitempty"`
+	PayloadBase64 string `json:"payloadBase64,omitempty"`
+}
+
+// CompositeKey is the decoded form of a composite key.
+type CompositeKey struct {
+	ObjectType string   `json:"objectType"`
+	Attributes []string `json:"attributes"`
+}
+
+// Warning points at a key or transaction that is likely to cause trouble on
+// a real network.
+type Warning struct {
+	Kind         string `json:"kind"`
+	Key          string `json:"key,omitempty"`
+	Transactions []int  `json:"transactions"`
+	Message      string `json:"message"`
+}
+
+// Inspect submits every transaction of the fixture to a ledger seeded with
+// the fixture state and reports each read/write set, range query and event,
+// along with warnings for oversized values, hot keys and range queries that
+// risk phantom reads. Successful transactions are committed, so each proposal
+// sees the writes of the ones before it.
+func Inspect(newChaincode func() (*kalpsdk.ContractChaincode, error), f *Fixture, opts InspectOptions) (*Report, error) {
+	cc, err := newChaincode()
+	if err != nil {
+		return nil, err
+	}
+	ledger := NewLedger()
+	if err := f.Seed(ledger); err != nil {
+		return nil, err
+	}
+	n := f.NewNetwork(ledger)
+
+	report := &Report{Transactions: []TxReport{}, Warnings: []Warning{}}
+	writers := make(map[string][]int)
+	for i, p := range f.Transactions {
+		res, err := n.Submit(cc, p)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d (%s): %v", i+1, p.Function, err)
+		}
+		tx := newTxReport(i+1, p, res)
+		report.Transactions = append(report.Transactions, tx)
+
+		for _, w := range tx.Writes {
+			if w.Size > opts.MaxValueSize {
+				report.Warnings = append(report.Warnings, Warning{
+					Kind:         WarnOversizedValue,
+					Key:          w.Key,
+					Transactions: []int{tx.Index},
+					Message:      fmt.Sprintf("value of %d bytes exceeds %d bytes", w.Size, opts.MaxValueSize),
+				})
+			}
+			if tx.Committed {
+				writers[w.Key] = append(writers[w.Key], tx.Index)
+			}
+		}
+		if tx.Event != nil && tx.Event.Size > opts.MaxValueSize {
+			report.Warnings = append(report.Warnings, Warning{
+				Kind:         WarnOversizedEvent,
+				Transactions: []int{tx.Index},
+				Message:      fmt.Sprintf("payload of event %s is %d bytes, exceeding %d bytes", tx.Event.Name, tx.Event.Size, opts.MaxValueSize),
+			})
+		}
+		for _, rq := range tx.RangeQueries {
+			if rq.PhantomReadRisk {
+				report.Warnings = append(report.Warnings, Warning{
+					Kind:         WarnPhantomRead,
+					Key:          rq.StartKey,
+					Transactions: []int{tx.Index},
+					Message:      fmt.Sprintf("range [%q, %q) is re-checked at commit; a concurrent insert or delete in it fails the transaction", rq.StartKey, rq.EndKey),
+				})
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(writers))
+	for key := range writers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		txs := writers[key]
+		if opts.HotKeyWriters > 0 && len(txs) >= opts.HotKeyWriters {
+			report.Warnings = append(report.Warnings, Warning{
+				Kind:         WarnHotKey,
+				Key:          key,
+				Transactions: txs,
+				Message:      fmt.Sprintf("written by %d transactions; any transaction that reads it while another write is pending fails with MVCC_READ_CONFLICT", len(txs)),
+			})
+		}
+	}
+	return report, nil
+}
+
+func newTxReport(index int, p Proposal, res *Result) TxReport {
+	user := p.User
+	if user == "" {
+		user = "admin"
+	}
+	tx := TxReport{
+		Index:        index,
+		Function:     p.Function,
+		Args:         p.Args,
+		User:         user,
+		TxID:         res.TxID,
+		Timestamp:    res.Timestamp.AsTime().Format(time.RFC3339Nano),
+		Status:       res.Status,
+		Message:      res.Message,
+		Payload:      string(res.Payload),
+		Committed:    res.OK(),
+		Reads:        []ReadReport{},
+		Writes:       []WriteReport{},
+		RangeQueries: []RangeQueryReport{},
+		Event:        newEventReport(res.Event),
+	}
+	for _, r := range sortedReads(res.RWSet.Reads) {
+		tx.Reads = append(tx.Reads, ReadReport{Key: r.Key, Composite: decodeCompositeKey(r.Key), Version: r.Version})
+	}
+	for _, w := range res.RWSet.Writes {
+		wr := WriteReport{Key: w.Key, Composite: decodeCompositeKey(w.Key), IsDelete: w.IsDelete, Size: len(w.Value)}
+		if !w.IsDelete {
+			wr.Value, wr.ValueBase64 = encodeValue(w.Value)
+		}
+		tx.Writes = append(tx.Writes, wr)
+	}
+	sort.Slice(tx.Writes, func(i, j int) bool { return tx.Writes[i].Key < tx.Writes[j].Key })
+	for _, rq := range res.RWSet.RangeQueries {
+		keys := make([]string, 0, len(rq.Reads))
+		for _, r := range rq.Reads {
+			keys = append(keys, r.Key)
+		}
+		tx.RangeQueries = append(tx.RangeQueries, RangeQueryReport{
+			StartKey:        rq.StartKey,
+			EndKey:          rq.EndKey,
+			ItrExhausted:    rq.ItrExhausted,
+			Keys:            keys,
+			PhantomReadRisk: len(res.RWSet.Writes) > 0,
+		})
+	}
+	return tx
+}
+
+func newEventReport(e *pb.ChaincodeEvent) *EventReport {
+	if e == nil {
+		return nil
+	}
+	er := &EventReport{Name: e.EventName, Size: len(e.Payload)}
+	er.Payload, er.PayloadBase64 = encodeValue(e.Payload)
+	return er
+}
+
+func decodeCompositeKey(key string) *CompositeKey {
+	objectType, attributes, err := SplitCompositeKey(key)
+	if err != nil {
+		return nil
+	}
+	return &CompositeKey{ObjectType: objectType, Attributes: attributes}
+}
+
+// encodeValue returns value as text when it is valid UTF-8 and as base64
+// otherwise.
+func encodeValue(value []byte) (text, b64 string) {
+	if utf8.Valid(value) {
+		return string(value), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(value)
+}
@@ -0,0 +1,178 @@
+// Package ledgersim runs kalpsdk chaincode against in-memory ledgers.
+//
+// A Ledger holds committed world state and key history. A Network endorses
+// proposals against a Ledger through a Stub that implements the shim
+// interface, records the read/write set, the range queries and the chaincode
+// event of the simulation, and commits the result with Fabric's MVCC checks.
+// It is meant for tooling and local experiments; it does not model private
+// data, endorsement policies or state-based endorsement.
+package ledgersim
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Version identifies the transaction that last wrote a key, like the block and
+// transaction numbers Fabric records in the state database.
+type Version struct {
+	BlockNum uint64 `json:"blockNum"`
+	TxNum    uint64 `json:"txNum"`
+}
+
+// HistoryEntry is one committed modification of a key.
+type HistoryEntry struct {
+	TxID      string
+	Value     []byte
+	Timestamp *timestamppb.Timestamp
+	IsDelete  bool
+}
+
+type entry struct {
+	value   []byte
+	version Version
+}
+
+// Ledger is an in-memory stand-in for a peer's world state and history
+// database. Every committed transaction is placed in a block of its own.
+type Ledger struct {
+	state   map[string]entry
+	history map[string][]HistoryEntry
+	height  uint64
+}
+
+// NewLedger returns an empty ledger.
+func NewLedger() *Ledger {
+	return &Ledger{
+		state:   make(map[string]entry),
+		history: make(map[string][]HistoryEntry),
+	}
+}
+
+// Height returns the number of blocks committed so far.
+func (l *Ledger) Height() uint64 {
+	return l.height
+}
+
+// GetState returns the committed value of key, or nil when it does not exist.
+func (l *Ledger) GetState(key string) []byte {
+	return l.state[key].value
+}
+
+// Keys returns every committed key, composite keys included, in lexical order.
+func (l *Ledger) Keys() []string {
+	keys := make([]string, 0, len(l.state))
+	for key := range l.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// History returns the committed modifications of key, newest first.
+func (l *Ledger) History(key string) []HistoryEntry {
+	entries := l.history[key]
+	out := make([]HistoryEntry, len(entries))
+	for i, e := range entries {
+		out[len(entries)-1-i] = e
+	}
+	return out
+}
+
+// Put commits a single write outside of any proposal, for seeding state.
+func (l *Ledger) Put(txID string, key string, value []byte) {
+	l.height++
+	l.apply(txID, nil, []KVWrite{{Key: key, Value: value}})
+}
+
+// Commit validates a simulated transaction against the current state and, when
+// nothing it read has changed since endorsement, applies its writes in a new
+// block. A stale read or a changed range query result is reported as an MVCC
+// conflict, as the committing peer would.
+func (l *Ledger) Commit(txID string, ts *timestamppb.Timestamp, rw *RWSet) error {
+	for _, read := range rw.Reads {
+		if !l.versionMatches(read) {
+			return fmt.Errorf("MVCC_READ_CONFLICT: key %q changed since endorsement", read.Key)
+		}
+	}
+	for _, rq := range rw.RangeQueries {
+		current := l.rangeReads(rq.StartKey, rq.EndKey)
+		if !rq.ItrExhausted && len(current) > len(rq.Reads) {
+			current = current[:len(rq.Reads)]
+		}
+		if !sameReads(current, rq.Reads) {
+			return fmt.Errorf("PHANTOM_READ_CONFLICT: range [%q, %q) changed since endorsement", rq.StartKey, rq.EndKey)
+		}
+	}
+	l.height++
+	l.apply(txID, ts, rw.Writes)
+	return nil
+}
+
+func (l *Ledger) apply(txID string, ts *timestamppb.Timestamp, writes []KVWrite) {
+	version := Version{BlockNum: l.height, TxNum: 0}
+	for _, w := range writes {
+		if w.IsDelete {
+			delete(l.state, w.Key)
+		} else {
+			l.state[w.Key] = entry{value: w.Value, version: version}
+		}
+		l.history[w.Key] = append(l.history[w.Key], HistoryEntry{
+			TxID:      txID,
+			Value:     w.Value,
+			Timestamp: ts,
+			IsDelete:  w.IsDelete,
+		})
+	}
+}
+
+func (l *Ledger) read(key string) KVRead {
+	e, ok := l.state[key]
+	if !ok {
+		return KVRead{Key: key}
+	}
+	v := e.version
+	return KVRead{Key: key, Version: &v}
+}
+
+func (l *Ledger) versionMatches(read KVRead) bool {
+	current := l.read(read.Key)
+	if current.Version == nil || read.Version == nil {
+		return current.Version == nil && read.Version == nil
+	}
+	return *current.Version == *read.Version
+}
+
+// rangeKeys returns the committed keys in [startKey, endKey), with an empty
+// endKey meaning unbounded.
+func (l *Ledger) rangeKeys(startKey, endKey string) []string {
+	var keys []string
+	for _, key := range l.Keys() {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (l *Ledger) rangeReads(startKey, endKey string) []KVRead {
+	var reads []KVRead
+	for _, key := range l.rangeKeys(startKey, endKey) {
+		reads = append(reads, l.read(key))
+	}
+	return reads
+}
+
+func sameReads(a, b []KVRead) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Version == nil || b[i].Version == nil || *a[i].Version != *b[i].Version {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package ledgersim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// DefaultChannelID is the channel proposals are sent to unless configured.
+	DefaultChannelID = "kalp"
+
+	// DefaultMSPID is the MSP of simulated clients unless configured.
+	DefaultMSPID = "mailabs"
+)
+
+// genesis is the timestamp of the first proposal without an explicit one.
+// Later proposals are one second apart.
+var genesis = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Chaincode is implemented by *kalpsdk.ContractChaincode.
+type Chaincode interface {
+	Invoke(stub kalpsdk.ChaincodeStubInterface) pb.Response
+}
+
+// Proposal is a transaction proposal submitted by a simulated client. Args
+// are the string arguments after the function name, as the Kalp gateway sends
+// them.
+type Proposal struct {
+	Function  string            `json:"function"`
+	Args      []string          `json:"args,omitempty"`
+	User      string            `json:"user,omitempty"`
+	MSPID     string            `json:"mspId,omitempty"`
+	TxID      string            `json:"txId,omitempty"`
+	Timestamp time.Time         `json:"timestamp,omitempty"`
+	Transient map[string]string `json:"transient,omitempty"`
+}
+
+// Result is the outcome of simulating a proposal.
+type Result struct {
+	TxID      string
+	Timestamp *timestamppb.Timestamp
+	Status    int32
+	Message   string
+	Payload   []byte
+	RWSet     *RWSet
+	Event     *pb.ChaincodeEvent
+}
+
+// OK reports whether the chaincode returned a successful response.
+func (r *Result) OK() bool {
+	return r.Status < shim.ERRORTHRESHOLD
+}
+
+// Network endorses and commits proposals against a single Ledger. It also
+// answers the kyc chaincode queries kalpsdk makes for the *WithKYC calls.
+type Network struct {
+	Ledger    *Ledger
+	ChannelID string
+	MSPID     string
+
+	// KYC lists the users the kyc chaincode reports as verified.
+	KYC map[string]bool
+
+	identities map[string]*Identity
+	seq        int
+}
+
+// NewNetwork returns a network over ledger with the default channel and MSP.
+func NewNetwork(ledger *Ledger) *Network {
+	return &Network{
+		Ledger:     ledger,
+		ChannelID:  DefaultChannelID,
+		MSPID:      DefaultMSPID,
+		KYC:        make(map[string]bool),
+		identities: make(map[string]*Identity),
+	}
+}
+
+// Identity returns the identity of user in mspID, creating it on first use.
+func (n *Network) Identity(mspID, user string) (*Identity, error) {
+	key := mspID + "/" + user
+	if id, ok := n.identities[key]; ok {
+		return id, nil
+	}
+	id, err := NewIdentity(mspID, user)
+	if err != nil {
+		return nil, err
+	}
+	n.identities[key] = id
+	return id, nil
+}
+
+// ShareIdentities makes n use the same client certificates as other, so that
+// both produce byte-identical creators for the same user.
+func (n *Network) ShareIdentities(other *Network) {
+	n.identities = other.identities
+}
+
+// Endorse simulates p against the committed state without committing it.
+// Proposals without a TxID or timestamp get deterministic defaults derived
+// from their position in the sequence of proposals endorsed by n.
+func (n *Network) Endorse(cc Chaincode, p Proposal) (*Result, error) {
+	n.seq++
+	if p.User == "" {
+		p.User = "admin"
+	}
+	if p.MSPID == "" {
+		p.MSPID = n.MSPID
+	}
+	if p.TxID == "" {
+		p.TxID = defaultTxID(n.seq, p)
+	}
+	if p.Timestamp.IsZero() {
+		p.Timestamp = genesis.Add(time.Duration(n.seq-1) * time.Second)
+	}
+
+	id, err := n.Identity(p.MSPID, p.User)
+	if err != nil {
+		return nil, err
+	}
+
+	stub := n.newStub(p, id)
+	resp := cc.Invoke(stub)
+	return &Result{
+		TxID:      p.TxID,
+		Timestamp: stub.timestamp,
+		Status:    resp.Status,
+		Message:   resp.Message,
+		Payload:   resp.Payload,
+		RWSet:     stub.rwset,
+		Event:     stub.event,
+	}, nil
+}
+
+// Submit endorses p and, when the chaincode succeeds, commits the result. A
+// failed chaincode response is returned without error and leaves the ledger
+// unchanged; an MVCC conflict at commit is returned as an error.
+func (n *Network) Submit(cc Chaincode, p Proposal) (*Result, error) {
+	res, err := n.Endorse(cc, p)
+	if err != nil || !res.OK() {
+		return res, err
+	}
+	return res, n.Ledger.Commit(res.TxID, res.Timestamp, res.RWSet)
+}
+
+func (n *Network) newStub(p Proposal, id *Identity) *Stub {
+	args := [][]byte{[]byte(p.Function)}
+	for _, arg := range p.Args {
+		args = append(args, []byte(arg))
+	}
+	transient := make(map[string][]byte, len(p.Transient))
+	for k, v := range p.Transient {
+		transient[k] = []byte(v)
+	}
+	return &Stub{
+		ledger:    n.Ledger,
+		txID:      p.TxID,
+		channelID: n.ChannelID,
+		args:      args,
+		creator:   id.Creator(),
+		timestamp: timestamppb.New(p.Timestamp),
+		transient: transient,
+		invoke:    n.invokeChaincode,
+		rwset:     newRWSet(),
+	}
+}
+
+// invokeChaincode answers chaincode-to-chaincode calls. Only the kyc
+// chaincode used by kalpsdk is available.
+func (n *Network) invokeChaincode(name string, args [][]byte, channel string) pb.Response {
+	if name != "kyc" || len(args) < 2 {
+		return shim.Error(fmt.Sprintf("chaincode %s is not available in simulation", name))
+	}
+	switch string(args[0]) {
+	case "KycExists":
+		return shim.Success([]byte(strconv.FormatBool(n.KYC[string(args[1])])))
+	case "CreateKyc":
+		n.KYC[string(args[1])] = true
+		return shim.Success(nil)
+	}
+	return shim.Error(fmt.Sprintf("function %s of chaincode kyc is not available in simulation", args[0]))
+}
+
+func defaultTxID(seq int, p Proposal) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s", seq, p.User, p.Function)
+	for _, arg := range p.Args {
+		fmt.Fprintf(h, "\x00%s", arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
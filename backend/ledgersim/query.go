@@ -0,0 +1,112 @@
+package ledgersim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// stateIterator walks a snapshot of committed keys. When it belongs to a range
+// query, every key returned is recorded in the query's RangeQueryInfo.
+type stateIterator struct {
+	stub  *Stub
+	kvs   []*queryresult.KV
+	pos   int
+	query int // index into the RWSet range queries, -1 for rich queries
+	// paged is set when the keys end at a page boundary rather than at the
+	// end of the range, so running out of them does not exhaust the range.
+	paged bool
+}
+
+func (it *stateIterator) HasNext() bool {
+	if it.pos < len(it.kvs) {
+		return true
+	}
+	if it.query >= 0 && !it.paged {
+		it.stub.rwset.RangeQueries[it.query].ItrExhausted = true
+	}
+	return false
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := it.kvs[it.pos]
+	it.pos++
+	if it.query >= 0 {
+		rq := &it.stub.rwset.RangeQueries[it.query]
+		rq.Reads = append(rq.Reads, it.stub.ledger.read(kv.Key))
+	}
+	return kv, nil
+}
+
+func (it *stateIterator) Close() error {
+	return nil
+}
+
+type historyIterator struct {
+	mods []*queryresult.KeyModification
+	pos  int
+}
+
+func (it *historyIterator) HasNext() bool {
+	return it.pos < len(it.mods)
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	mod := it.mods[it.pos]
+	it.pos++
+	return mod, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+// matchSelector reports whether the JSON document value satisfies a CouchDB
+// selector. Only field equality and the $eq operator are supported, which
+// covers the lookups kalpsdk itself performs.
+func matchSelector(selector map[string]interface{}, value []byte) bool {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return false
+	}
+	for field, want := range selector {
+		if ops, ok := want.(map[string]interface{}); ok {
+			eq, ok := ops["$eq"]
+			if !ok || len(ops) != 1 {
+				return false
+			}
+			want = eq
+		}
+		if !jsonEqual(doc[field], want) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSelector(query string) (map[string]interface{}, error) {
+	var q struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(query), &q); err != nil {
+		return nil, fmt.Errorf("invalid query %q: %v", query, err)
+	}
+	if q.Selector == nil {
+		return nil, fmt.Errorf("query %q has no selector", query)
+	}
+	return q.Selector, nil
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
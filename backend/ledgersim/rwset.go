@@ -0,0 +1,65 @@
+package ledgersim
+
+// KVRead records a key read during simulation and the committed version it
+// had. Version is nil when the key did not exist.
+type KVRead struct {
+	Key     string   `json:"key"`
+	Version *Version `json:"version,omitempty"`
+}
+
+// KVWrite records a key written or deleted during simulation.
+type KVWrite struct {
+	Key      string `json:"key"`
+	Value    []byte `json:"value,omitempty"`
+	IsDelete bool   `json:"isDelete,omitempty"`
+}
+
+// RangeQueryInfo records a range query and the keys it returned. The committer
+// re-executes the query to detect phantom reads.
+type RangeQueryInfo struct {
+	StartKey     string   `json:"startKey"`
+	EndKey       string   `json:"endKey"`
+	ItrExhausted bool     `json:"itrExhausted"`
+	Reads        []KVRead `json:"reads"`
+}
+
+// RWSet is the read/write set produced by simulating one transaction. Reads
+// keep the version seen by the first read of a key, writes keep the last value
+// written to a key, both in order of first access.
+type RWSet struct {
+	Reads        []KVRead         `json:"reads"`
+	Writes       []KVWrite        `json:"writes"`
+	RangeQueries []RangeQueryInfo `json:"rangeQueries"`
+
+	readIndex  map[string]int
+	writeIndex map[string]int
+}
+
+func newRWSet() *RWSet {
+	return &RWSet{
+		readIndex:  make(map[string]int),
+		writeIndex: make(map[string]int),
+	}
+}
+
+func (rw *RWSet) addRead(read KVRead) {
+	if _, ok := rw.readIndex[read.Key]; ok {
+		return
+	}
+	rw.readIndex[read.Key] = len(rw.Reads)
+	rw.Reads = append(rw.Reads, read)
+}
+
+func (rw *RWSet) addWrite(write KVWrite) {
+	if i, ok := rw.writeIndex[write.Key]; ok {
+		rw.Writes[i] = write
+		return
+	}
+	rw.writeIndex[write.Key] = len(rw.Writes)
+	rw.Writes = append(rw.Writes, write)
+}
+
+func (rw *RWSet) addRangeQuery(startKey, endKey string) int {
+	rw.RangeQueries = append(rw.RangeQueries, RangeQueryInfo{StartKey: startKey, EndKey: endKey})
+	return len(rw.RangeQueries) - 1
+}
@@ -0,0 +1,43 @@
+package ledgersim
+
+import (
+	"io"
+
+	"krc20/snapshot"
+)
+
+// Export writes every committed key, composite keys included, to w in key
+// order.
+func (l *Ledger) Export(w *snapshot.Writer) error {
+	for _, key := range l.Keys() {
+		if err := w.Write(snapshot.NewRecord(key, l.GetState(key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import commits every record of r in a single block and returns the number
+// of keys written. Nothing is committed when a record cannot be read.
+func (l *Ledger) Import(r *snapshot.Reader) (int, error) {
+	var writes []KVWrite
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		value, err := rec.Bytes()
+		if err != nil {
+			return 0, err
+		}
+		writes = append(writes, KVWrite{Key: rec.Key, Value: value})
+	}
+	if len(writes) > 0 {
+		l.height++
+		l.apply("snapshot", nil, writes)
+	}
+	return len(writes), nil
+}
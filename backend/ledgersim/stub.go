@@ -0,0 +1,351 @@
+package ledgersim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	emptyKeySubstitute    = "\x01"
+)
+
+var errPrivateData = errors.New("private data is not supported by ledgersim")
+
+// Stub implements shim.ChaincodeStubInterface for a single simulated
+// proposal. Reads see only committed state, as on a peer, and every access is
+// recorded in the stub's RWSet.
+type Stub struct {
+	ledger    *Ledger
+	txID      string
+	channelID string
+	args      [][]byte
+	creator   []byte
+	timestamp *timestamppb.Timestamp
+	transient map[string][]byte
+	invoke    func(name string, args [][]byte, channel string) pb.Response
+
+	rwset *RWSet
+	event *pb.ChaincodeEvent
+}
+
+// RWSet returns the read/write set recorded so far.
+func (s *Stub) RWSet() *RWSet {
+	return s.rwset
+}
+
+// Event returns the chaincode event set by the transaction, if any.
+func (s *Stub) Event() *pb.ChaincodeEvent {
+	return s.event
+}
+
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	var out []byte
+	for _, arg := range s.args {
+		out = append(out, arg...)
+	}
+	return out, nil
+}
+
+func (s *Stub) GetTxID() string {
+	return s.txID
+}
+
+func (s *Stub) GetChannelID() string {
+	return s.channelID
+}
+
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	if s.invoke == nil {
+		return shim.Error(fmt.Sprintf("chaincode %s is not available in simulation", chaincodeName))
+	}
+	return s.invoke(chaincodeName, args, channel)
+}
+
+func (s *Stub) GetState(key string) ([]byte, error) {
+	s.rwset.addRead(s.ledger.read(key))
+	return s.ledger.GetState(key), nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	s.rwset.addWrite(KVWrite{Key: key, Value: append([]byte(nil), value...)})
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	s.rwset.addWrite(KVWrite{Key: key, IsDelete: true})
+	return nil
+}
+
+func (s *Stub) SetStateValidationParameter(key string, ep []byte) error {
+	return errors.New("state-based endorsement is not supported by ledgersim")
+}
+
+func (s *Stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return nil, errors.New("state-based endorsement is not supported by ledgersim")
+}
+
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return s.rangeIterator(startKey, endKey, 0), nil
+}
+
+func (s *Stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, nil, err
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return s.pagedIterator(startKey, endKey, pageSize, bookmark)
+}
+
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.rangeIterator(startKey, endKey, 0), nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.pagedIterator(startKey, endKey, pageSize, bookmark)
+}
+
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	return SplitCompositeKey(compositeKey)
+}
+
+// GetQueryResult evaluates a CouchDB selector against committed state. As on
+// a peer, rich queries are not recorded in the read set.
+func (s *Stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	selector, err := parseSelector(query)
+	if err != nil {
+		return nil, err
+	}
+	it := &stateIterator{stub: s, query: -1}
+	for _, key := range s.ledger.Keys() {
+		if value := s.ledger.GetState(key); matchSelector(selector, value) {
+			it.kvs = append(it.kvs, &queryresult.KV{Namespace: s.channelID, Key: key, Value: value})
+		}
+	}
+	return it, nil
+}
+
+func (s *Stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iter, err := s.GetQueryResult(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	it := iter.(*stateIterator)
+	for len(it.kvs) > 0 && bookmark != "" && it.kvs[0].Key < bookmark {
+		it.kvs = it.kvs[1:]
+	}
+	meta := &pb.QueryResponseMetadata{}
+	if pageSize > 0 && len(it.kvs) > int(pageSize) {
+		meta.Bookmark = it.kvs[pageSize].Key
+		it.kvs = it.kvs[:pageSize]
+	}
+	meta.FetchedRecordsCount = int32(len(it.kvs))
+	return it, meta, nil
+}
+
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	it := &historyIterator{}
+	for _, h := range s.ledger.History(key) {
+		it.mods = append(it.mods, &queryresult.KeyModification{
+			TxId:      h.TxID,
+			Value:     h.Value,
+			Timestamp: h.Timestamp,
+			IsDelete:  h.IsDelete,
+		})
+	}
+	return it, nil
+}
+
+func (s *Stub) GetPrivateData(collection, key string) ([]byte, error) {
+	return nil, errPrivateData
+}
+
+func (s *Stub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	return nil, errPrivateData
+}
+
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	return errPrivateData
+}
+
+func (s *Stub) DelPrivateData(collection, key string) error {
+	return errPrivateData
+}
+
+func (s *Stub) PurgePrivateData(collection, key string) error {
+	return errPrivateData
+}
+
+func (s *Stub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return errPrivateData
+}
+
+func (s *Stub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	return nil, errPrivateData
+}
+
+func (s *Stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errPrivateData
+}
+
+func (s *Stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errPrivateData
+}
+
+func (s *Stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errPrivateData
+}
+
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *Stub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Stub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+func (s *Stub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return nil, errors.New("signed proposals are not available in simulation")
+}
+
+func (s *Stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return s.timestamp, nil
+}
+
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	s.event = &pb.ChaincodeEvent{EventName: name, Payload: payload, TxId: s.txID}
+	return nil
+}
+
+func (s *Stub) rangeIterator(startKey, endKey string, limit int) *stateIterator {
+	it := &stateIterator{stub: s, query: s.rwset.addRangeQuery(startKey, endKey)}
+	for _, key := range s.ledger.rangeKeys(startKey, endKey) {
+		if limit > 0 && len(it.kvs) == limit {
+			break
+		}
+		it.kvs = append(it.kvs, &queryresult.KV{Namespace: s.channelID, Key: key, Value: s.ledger.GetState(key)})
+	}
+	return it
+}
+
+func (s *Stub) pagedIterator(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		if bookmark < startKey || (endKey != "" && bookmark >= endKey) {
+			return nil, nil, fmt.Errorf("bookmark %q is outside the queried range", bookmark)
+		}
+		startKey = bookmark
+	}
+	limit := 0
+	if pageSize > 0 {
+		// One extra key tells whether there is a next page.
+		limit = int(pageSize) + 1
+	}
+	it := s.rangeIterator(startKey, endKey, limit)
+	meta := &pb.QueryResponseMetadata{}
+	if pageSize > 0 && len(it.kvs) > int(pageSize) {
+		meta.Bookmark = it.kvs[pageSize].Key
+		it.kvs = it.kvs[:pageSize]
+		it.paged = true
+	}
+	meta.FetchedRecordsCount = int32(len(it.kvs))
+	return it, meta, nil
+}
+
+// SplitCompositeKey splits a composite key into its object type and
+// attributes, like shim.ChaincodeStub.SplitCompositeKey.
+func SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) {
+		return "", nil, fmt.Errorf("key %q is not a composite key", compositeKey)
+	}
+	parts := strings.Split(compositeKey[1:], "\x00")
+	if len(parts) < 2 || parts[len(parts)-1] != "" {
+		return "", nil, fmt.Errorf("key %q is not a composite key", compositeKey)
+	}
+	return parts[0], parts[1 : len(parts)-1], nil
+}
+
+// IsCompositeKey reports whether key was built with CreateCompositeKey.
+func IsCompositeKey(key string) bool {
+	return strings.HasPrefix(key, compositeKeyNamespace)
+}
+
+func partialCompositeKeyRange(objectType string, keys []string) (string, string, error) {
+	startKey, err := shim.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return "", "", err
+	}
+	return startKey, startKey + string(utf8.MaxRune), nil
+}
+
+func validateSimpleKeys(keys ...string) error {
+	for _, key := range keys {
+		if IsCompositeKey(key) {
+			return fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return nil
+}
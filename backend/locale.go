@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+	"golang.org/x/text/language"
+)
+
+const (
+	// localizedGreetingObjectType is the composite key type of localized
+	// greetings, keyed by canonical BCP 47 tag.
+	localizedGreetingObjectType = "localizedGreeting"
+
+	// defaultLocaleKey holds the locale set by SetDefaultLocale.
+	defaultLocaleKey = "defaultLocale"
+
+	// defaultLocale applies until SetDefaultLocale is called.
+	defaultLocale = "en"
+)
+
+// LocalizedGreetings is every localized greeting by canonical BCP 47 tag,
+// together with the locale GetLocalizedGreeting falls back to.
+type LocalizedGreetings struct {
+	DefaultLocale string            `json:"defaultLocale"`
+	Greetings     map[string]string `json:"greetings"`
+}
+
+// SetLocalizedGreeting sets the greeting for a BCP 47 locale such as "en",
+// "pt-BR" or "zh-Hant". It is validated like SetGreeting and raises a
+// GreetingChanged event carrying the locale. There is no paid variant, so it
+// is rejected while a payment is required.
+func (s *SmartContract) SetLocalizedGreeting(ctx kalpsdk.TransactionContextInterface, locale string, greeting string) error {
+	policy, err := s.GetPaymentPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if policy.Required {
+		return fmt.Errorf("greetings require a payment, localized greetings cannot be set")
+	}
+	tag, err := parseLocale(locale)
+	if err != nil {
+		return err
+	}
+	greeting, err = s.validateGreeting(ctx, greeting)
+	if err != nil {
+		return err
+	}
+	record, err := newGreeting(ctx, greeting)
+	if err != nil {
+		return err
+	}
+	put, err := greetingWriter(ctx)
+	if err != nil {
+		return err
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal greeting: %v", err)
+	}
+
+	key, err := ctx.CreateCompositeKey(localizedGreetingObjectType, []string{tag.String()})
+	if err != nil {
+		return fmt.Errorf("failed to create localized greeting key: %v", err)
+	}
+	oldBytes, err := ctx.GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read localized greeting: %v", err)
+	}
+	oldText := ""
+	if oldBytes != nil {
+		old, err := decodeGreeting(oldBytes)
+		if err != nil {
+			return err
+		}
+		oldText = old.Text
+	}
+	if err := put(key, recordBytes); err != nil {
+		return fmt.Errorf("failed to store localized greeting: %v", err)
+	}
+	return emitGreetingChanged(ctx, oldText, record, tag.String())
+}
+
+// GetLocalizedGreeting returns the greeting for locale. A locale without a
+// greeting of its own falls back to its parents, from region to language,
+// for example "pt-BR" to "pt", then in the same way to the default locale,
+// and finally to GetGreeting.
+func (s *SmartContract) GetLocalizedGreeting(ctx kalpsdk.TransactionContextInterface, locale string) (string, error) {
+	tag, err := parseLocale(locale)
+	if err != nil {
+		return "", err
+	}
+	fallback, err := getDefaultLocale(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range append(localeChain(tag), localeChain(fallback)...) {
+		key, err := ctx.CreateCompositeKey(localizedGreetingObjectType, []string{candidate})
+		if err != nil {
+			return "", fmt.Errorf("failed to create localized greeting key: %v", err)
+		}
+		greetingBytes, err := ctx.GetState(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to read localized greeting: %v", err)
+		}
+		if greetingBytes != nil {
+			greeting, err := decodeGreeting(greetingBytes)
+			if err != nil {
+				return "", err
+			}
+			return greeting.Text, nil
+		}
+	}
+	return s.GetGreeting(ctx)
+}
+
+// GetLocalizedGreetings returns the greeting of every locale that has one, so
+// that clients can pick one without a call per locale.
+func (s *SmartContract) GetLocalizedGreetings(ctx kalpsdk.TransactionContextInterface) (*LocalizedGreetings, error) {
+	fallback, err := getDefaultLocale(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := ctx.GetStateByPartialCompositeKey(localizedGreetingObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read localized greetings: %v", err)
+	}
+	defer iterator.Close()
+
+	all := &LocalizedGreetings{DefaultLocale: fallback.String(), Greetings: map[string]string{}}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read localized greetings: %v", err)
+		}
+		_, attributes, err := ctx.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 1 {
+			return nil, fmt.Errorf("invalid localized greeting key %q", kv.Key)
+		}
+		greeting, err := decodeGreeting(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		all.Greetings[attributes[0]] = greeting.Text
+	}
+	return all, nil
+}
+
+// GetDefaultLocale returns the locale GetLocalizedGreeting falls back to.
+func (s *SmartContract) GetDefaultLocale(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	tag, err := getDefaultLocale(ctx)
+	if err != nil {
+		return "", err
+	}
+	return tag.String(), nil
+}
+
+// SetDefaultLocale changes the locale GetLocalizedGreeting falls back to.
+func (s *SmartContract) SetDefaultLocale(ctx kalpsdk.TransactionContextInterface, locale string) error {
+	tag, err := parseLocale(locale)
+	if err != nil {
+		return err
+	}
+	if err := ctx.PutStateWithoutKYC(defaultLocaleKey, []byte(tag.String())); err != nil {
+		return fmt.Errorf("failed to store default locale: %v", err)
+	}
+	return nil
+}
+
+func getDefaultLocale(ctx kalpsdk.TransactionContextInterface) (language.Tag, error) {
+	localeBytes, err := ctx.GetState(defaultLocaleKey)
+	if err != nil {
+		return language.Und, fmt.Errorf("failed to read default locale: %v", err)
+	}
+	if localeBytes == nil {
+		return language.MustParse(defaultLocale), nil
+	}
+	return parseLocale(string(localeBytes))
+}
+
+// parseLocale parses a BCP 47 tag and drops everything but its language,
+// script and region, so that equivalent tags share a key.
+func parseLocale(locale string) (language.Tag, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return language.Und, fmt.Errorf("invalid locale %q: %v", locale, err)
+	}
+	if tag == language.Und {
+		return language.Und, fmt.Errorf("invalid locale %q: language is undetermined", locale)
+	}
+	tag, err = language.Compose(tag.Raw())
+	if err != nil {
+		return language.Und, fmt.Errorf("invalid locale %q: %v", locale, err)
+	}
+	return tag, nil
+}
+
+// localeChain returns tag and its parents, most specific first, ending with
+// the bare language. "zh-TW" gives zh-TW, zh-Hant and zh.
+func localeChain(tag language.Tag) []string {
+	var chain []string
+	for t := tag; t != language.Und; t = t.Parent() {
+		chain = append(chain, t.String())
+	}
+	base, _ := tag.Base()
+	if last := base.String(); len(chain) == 0 || chain[len(chain)-1] != last {
+		chain = append(chain, last)
+	}
+	return chain
+}
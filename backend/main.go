@@ -1,3 +1,5 @@
+//go:build !sim
+
 package main
 
 import (
@@ -7,19 +9,13 @@ import (
 )
 
 func main() {
-	contract := kalpsdk.Contract{IsPayableContract: false}
-	contract.Logger = kalpsdk.NewLogger()
-
-	// Create a new instance of your SmartContract
-	smartContract := &SmartContract{contract}
-
 	// Create a new instance of KalpContractChaincode with your smart contract
-	chaincode, err := kalpsdk.NewChaincode(smartContract)
+	chaincode, err := newChaincode()
 	if err != nil {
 		log.Panicf("Error creating KalpContractChaincode: %v", err)
 	}
 
-	contract.Logger.Info("Initializing Kalp DLT Greeting Smart Contract")
+	kalpsdk.NewLogger().Info("Initializing Kalp DLT Greeting Smart Contract")
 
 	// Start the chaincode
 	if err := chaincode.Start(); err != nil {
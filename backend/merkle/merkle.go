@@ -0,0 +1,137 @@
+// Package merkle builds and verifies the SHA-256 Merkle trees of airdrops.
+//
+// A leaf allocates an amount of tokens to a user:
+//
+//	leaf = SHA-256(0x00 || len(userID) as 4 bytes big-endian || userID || amount)
+//
+// where amount is the decimal string of the allocation. Interior nodes hash
+// their children in order:
+//
+//	node = SHA-256(0x01 || left || right)
+//
+// The prefixes keep a leaf from being passed off as a node. When a level has
+// an odd number of nodes, the last one moves up to the next level unchanged,
+// so a proof has no sibling for that level. The position of a leaf in the
+// tree, and so the order in which a proof is applied, follows from its index
+// and the number of leaves.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// LeafHash returns the leaf allocating amount to userID.
+func LeafHash(userID, amount string) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(userID)))
+	h.Write(length[:])
+	h.Write([]byte(userID))
+	h.Write([]byte(amount))
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Tree is a Merkle tree over a list of leaves.
+type Tree struct {
+	// levels[0] holds the leaves and the last level the root.
+	levels [][][]byte
+}
+
+// New builds the tree over leaves, in order.
+func New(leaves [][]byte) (*Tree, error) {
+	if len(leaves) == 0 {
+		return nil, errors.New("a tree needs at least one leaf")
+	}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		if len(leaf) != sha256.Size {
+			return nil, fmt.Errorf("leaf %d is %d bytes, expected %d", i, len(leaf), sha256.Size)
+		}
+		level[i] = leaf
+	}
+	t := &Tree{levels: [][][]byte{level}}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t, nil
+}
+
+// Len returns the number of leaves.
+func (t *Tree) Len() int {
+	return len(t.levels[0])
+}
+
+// Root returns the root of the tree.
+func (t *Tree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the siblings of the leaf at index from the bottom up.
+func (t *Tree) Proof(index int) ([][]byte, error) {
+	if index < 0 || index >= t.Len() {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, t.Len())
+	}
+	var proof [][]byte
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// Verify reports whether proof shows that leaf is at index in the tree of
+// leafCount leaves with the given root.
+func Verify(root []byte, leafCount, index int, leaf []byte, proof [][]byte) bool {
+	if leafCount <= 0 || index < 0 || index >= leafCount {
+		return false
+	}
+	hash := leaf
+	for n := leafCount; n > 1; n = (n + 1) / 2 {
+		switch {
+		case index%2 == 1:
+			if len(proof) == 0 {
+				return false
+			}
+			hash = nodeHash(proof[0], hash)
+			proof = proof[1:]
+		case index+1 < n:
+			if len(proof) == 0 {
+				return false
+			}
+			hash = nodeHash(hash, proof[0])
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+	return len(proof) == 0 && bytes.Equal(hash, root)
+}
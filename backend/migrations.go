@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// migrations upgrades records written by earlier versions of the contract.
+// Append a step whenever the format of stored records changes, and make
+// reads accept the old format through the step's Upgrade function until
+// Migrate has finished on every deployment.
+var migrations = kalpext.Migrations{
+	{
+		Version:     1,
+		Description: "store greetings as JSON records instead of plain text",
+		Keys:        []string{latestGreetingKey},
+		ObjectTypes: []string{greetingObjectType},
+		Upgrade:     upgradeGreeting,
+	},
+}
+
+// Migrate upgrades at most batchSize stored records to the latest schema
+// version. Call it until the returned status is done.
+func (s *SmartContract) Migrate(ctx kalpsdk.TransactionContextInterface, batchSize int) (*kalpext.MigrationStatus, error) {
+	return migrations.Migrate(ctx, batchSize)
+}
+
+// GetSchemaVersion returns the schema version of the stored records and the
+// progress of Migrate.
+func (s *SmartContract) GetSchemaVersion(ctx kalpsdk.TransactionContextInterface) (*kalpext.MigrationStatus, error) {
+	return migrations.Status(ctx)
+}
+
+// upgradeGreeting turns a plain text greeting, as stored before greetings
+// became records, into a Greeting without author. Any value that does not
+// decode to a Greeting with text is plain text, including text that merely
+// looks like JSON, such as "{hello}" or "null".
+func upgradeGreeting(value []byte) ([]byte, error) {
+	var record Greeting
+	if err := json.Unmarshal(value, &record); err == nil && record.Text != "" {
+		return nil, nil
+	}
+	greetingBytes, err := json.Marshal(Greeting{Text: string(value)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal greeting: %v", err)
+	}
+	return greetingBytes, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// ModeratorRole can add and remove banned terms.
+	ModeratorRole = "MODERATOR"
+
+	// greetingPolicyKey holds the GreetingPolicy set by SetGreetingPolicy.
+	greetingPolicyKey = "greetingPolicy"
+
+	// bannedTermObjectType is the composite key type of banned terms, keyed
+	// by the lower-cased term.
+	bannedTermObjectType = "bannedTerm"
+
+	// maxBannedTermLength caps the length of a banned term in characters.
+	maxBannedTermLength = 64
+)
+
+// Greeting validation rules, reported in ValidationError.
+const (
+	RuleUTF8       = "utf8"
+	RuleEmpty      = "empty"
+	RuleMaxLength  = "maxLength"
+	RuleBannedTerm = "bannedTerm"
+)
+
+// GreetingPolicy configures how SetGreeting validates greetings.
+type GreetingPolicy struct {
+	// MaxLength is the maximum length of a greeting in characters.
+	MaxLength int `json:"maxLength"`
+
+	// Normalize converts greetings to Unicode normalization form C, so
+	// that visually identical greetings are stored identically.
+	Normalize bool `json:"normalize"`
+
+	// StripControl removes control characters such as newlines and
+	// terminal escapes.
+	StripControl bool `json:"stripControl"`
+}
+
+// defaultGreetingPolicy applies until SetGreetingPolicy is called.
+var defaultGreetingPolicy = GreetingPolicy{
+	MaxLength:    280,
+	Normalize:    true,
+	StripControl: true,
+}
+
+// ValidationError is returned for a greeting that breaks a rule.
+type ValidationError struct {
+	Rule    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("greeting rejected by rule %s: %s", e.Rule, e.Message)
+}
+
+// GetGreetingPolicy returns the policy greetings are validated against.
+func (s *SmartContract) GetGreetingPolicy(ctx kalpsdk.TransactionContextInterface) (*GreetingPolicy, error) {
+	policyBytes, err := ctx.GetState(greetingPolicyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read greeting policy: %v", err)
+	}
+	if policyBytes == nil {
+		policy := defaultGreetingPolicy
+		return &policy, nil
+	}
+	var policy GreetingPolicy
+	if err := json.Unmarshal(policyBytes, &policy); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal greeting policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// SetGreetingPolicy replaces the policy greetings are validated against.
+// Greetings already stored are not revalidated.
+func (s *SmartContract) SetGreetingPolicy(ctx kalpsdk.TransactionContextInterface, maxLength int, normalize bool, stripControl bool) error {
+	if maxLength <= 0 {
+		return fmt.Errorf("max length must be positive")
+	}
+	policyBytes, err := json.Marshal(GreetingPolicy{MaxLength: maxLength, Normalize: normalize, StripControl: stripControl})
+	if err != nil {
+		return fmt.Errorf("failed to marshal greeting policy: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(greetingPolicyKey, policyBytes); err != nil {
+		return fmt.Errorf("failed to store greeting policy: %v", err)
+	}
+	return nil
+}
+
+// AddBannedTerm rejects future greetings containing term, ignoring case.
+func (s *SmartContract) AddBannedTerm(ctx kalpsdk.TransactionContextInterface, term string) error {
+	key, err := bannedTermKey(ctx, term)
+	if err != nil {
+		return err
+	}
+	if err := ctx.PutStateWithoutKYC(key, []byte(foldTerm(term))); err != nil {
+		return fmt.Errorf("failed to store banned term: %v", err)
+	}
+	return nil
+}
+
+// RemoveBannedTerm allows greetings containing term again.
+func (s *SmartContract) RemoveBannedTerm(ctx kalpsdk.TransactionContextInterface, term string) error {
+	key, err := bannedTermKey(ctx, term)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read banned term: %v", err)
+	}
+	if existing == nil {
+		return fmt.Errorf("term %q is not banned", term)
+	}
+	if err := ctx.DelStateWithoutKYC(key); err != nil {
+		return fmt.Errorf("failed to remove banned term: %v", err)
+	}
+	return nil
+}
+
+// ListBannedTerms returns the banned terms in lower case, sorted.
+func (s *SmartContract) ListBannedTerms(ctx kalpsdk.TransactionContextInterface) ([]string, error) {
+	return getBannedTerms(ctx)
+}
+
+// validateGreeting applies the greeting policy and the banned terms to
+// greeting and returns it cleaned up.
+func (s *SmartContract) validateGreeting(ctx kalpsdk.TransactionContextInterface, greeting string) (string, error) {
+	policy, err := s.GetGreetingPolicy(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if !utf8.ValidString(greeting) {
+		return "", &ValidationError{Rule: RuleUTF8, Message: "greeting is not valid UTF-8"}
+	}
+	if policy.StripControl {
+		greeting = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, greeting)
+	}
+	if policy.Normalize {
+		greeting = norm.NFC.String(greeting)
+	}
+	greeting = strings.TrimSpace(greeting)
+
+	if greeting == "" {
+		return "", &ValidationError{Rule: RuleEmpty, Message: "greeting is empty"}
+	}
+	if length := utf8.RuneCountInString(greeting); length > policy.MaxLength {
+		return "", &ValidationError{Rule: RuleMaxLength, Message: fmt.Sprintf("greeting is %d characters, at most %d are allowed", length, policy.MaxLength)}
+	}
+
+	terms, err := getBannedTerms(ctx)
+	if err != nil {
+		return "", err
+	}
+	folded := foldTerm(greeting)
+	for _, term := range terms {
+		if strings.Contains(folded, term) {
+			return "", &ValidationError{Rule: RuleBannedTerm, Message: fmt.Sprintf("greeting contains banned term %q", term)}
+		}
+	}
+	return greeting, nil
+}
+
+func getBannedTerms(ctx kalpsdk.TransactionContextInterface) ([]string, error) {
+	iterator, err := ctx.GetStateByPartialCompositeKey(bannedTermObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read banned terms: %v", err)
+	}
+	defer iterator.Close()
+
+	terms := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read banned terms: %v", err)
+		}
+		terms = append(terms, string(kv.Value))
+	}
+	sort.Strings(terms)
+	return terms, nil
+}
+
+func bannedTermKey(ctx kalpsdk.TransactionContextInterface, term string) (string, error) {
+	if !utf8.ValidString(term) {
+		return "", fmt.Errorf("term is not valid UTF-8")
+	}
+	folded := foldTerm(term)
+	if folded == "" {
+		return "", fmt.Errorf("term must not be empty")
+	}
+	if utf8.RuneCountInString(folded) > maxBannedTermLength {
+		return "", fmt.Errorf("term is longer than %d characters", maxBannedTermLength)
+	}
+	key, err := ctx.CreateCompositeKey(bannedTermObjectType, []string{folded})
+	if err != nil {
+		return "", fmt.Errorf("failed to create banned term key: %v", err)
+	}
+	return key, nil
+}
+
+// foldTerm is the form banned terms are stored and matched in: normalized
+// and lower-cased, so that matching ignores case.
+func foldTerm(s string) string {
+	return strings.ToLower(norm.NFC.String(strings.TrimSpace(s)))
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// MultiTokenContractName is the name the multi-token contract is served
+// under.
+const MultiTokenContractName = "multitoken"
+
+const (
+	// multiTokenBalanceObjectType is the composite key type of multi-token
+	// balances, keyed by account and token ID.
+	multiTokenBalanceObjectType = "multiTokenBalance"
+
+	// multiTokenSupplyObjectType is the composite key type of the supply of
+	// each token ID.
+	multiTokenSupplyObjectType = "multiTokenSupply"
+
+	// multiTokenOperatorObjectType is the composite key type of operator
+	// approvals, keyed by owner and operator.
+	multiTokenOperatorObjectType = "multiTokenOperator"
+
+	// multiTokenURIObjectType is the composite key type of token URIs, keyed
+	// by token ID.
+	multiTokenURIObjectType = "multiTokenURI"
+
+	// multiTokenKindObjectType is the composite key type of the kind of each
+	// token ID, fixed when it is first minted.
+	multiTokenKindObjectType = "multiTokenKind"
+
+	// maxMultiTokenBatch caps the token IDs of one batch.
+	maxMultiTokenBatch = 100
+)
+
+const (
+	// TransferSingleEventName is the name of the event raised when tokens of
+	// one ID move.
+	TransferSingleEventName = "TransferSingle"
+
+	// TransferBatchEventName is the name of the event raised once for a
+	// whole batch.
+	TransferBatchEventName = "TransferBatch"
+
+	// URIEventName is the name of the event raised by SetURI.
+	URIEventName = "URI"
+)
+
+// Token kinds.
+const (
+	// MultiTokenFungible IDs can be minted any number of times.
+	MultiTokenFungible = "fungible"
+
+	// MultiTokenUnique IDs are minted once, as a single token.
+	MultiTokenUnique = "unique"
+)
+
+// multiTokenRoleRequirements lists the multi-token transactions that need a
+// role.
+var multiTokenRoleRequirements = kalpext.RoleRequirements{
+	"Mint":       MinterRole,
+	"MintBatch":  MinterRole,
+	"MintUnique": MinterRole,
+	"SetURI":     MinterRole,
+}
+
+// MultiTokenContract holds any number of token types, each identified by a
+// token ID. The first mint of an ID fixes its kind: IDs minted with
+// MintUnique are non-fungible and never minted again, all others fungible.
+type MultiTokenContract struct {
+	kalpsdk.Contract
+}
+
+// TransferSingleEvent is the payload of a TransferSingle event. From is
+// empty for mints and To for burns.
+type TransferSingleEvent struct {
+	Operator string `json:"operator"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	ID       string `json:"id"`
+	Value    string `json:"value"`
+}
+
+// TransferBatchEvent is the payload of a TransferBatch event. Values[i] of
+// IDs[i] moved.
+type TransferBatchEvent struct {
+	Operator string   `json:"operator"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	IDs      []string `json:"ids"`
+	Values   []string `json:"values"`
+}
+
+// URIEvent is the payload of a URI event.
+type URIEvent struct {
+	ID  string `json:"id"`
+	URI string `json:"uri"`
+}
+
+// Mint creates amount tokens of the fungible id for to.
+func (m *MultiTokenContract) Mint(ctx kalpsdk.TransactionContextInterface, to string, id string, amount string) error {
+	if err := fixMultiTokenKind(ctx, []string{id}, MultiTokenFungible); err != nil {
+		return err
+	}
+	return m.transfer(ctx, "", to, []string{id}, []string{amount}, false)
+}
+
+// MintBatch creates amounts[i] tokens of the fungible ids[i] for to.
+func (m *MultiTokenContract) MintBatch(ctx kalpsdk.TransactionContextInterface, to string, ids []string, amounts []string) error {
+	if err := fixMultiTokenKind(ctx, ids, MultiTokenFungible); err != nil {
+		return err
+	}
+	return m.transfer(ctx, "", to, ids, amounts, true)
+}
+
+// MintUnique creates the single token of the new non-fungible id for to.
+func (m *MultiTokenContract) MintUnique(ctx kalpsdk.TransactionContextInterface, to string, id string) error {
+	if err := fixMultiTokenKind(ctx, []string{id}, MultiTokenUnique); err != nil {
+		return err
+	}
+	return m.transfer(ctx, "", to, []string{id}, []string{"1"}, false)
+}
+
+// Burn destroys amount of the client's tokens of id.
+func (m *MultiTokenContract) Burn(ctx kalpsdk.TransactionContextInterface, id string, amount string) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	return m.transfer(ctx, owner, "", []string{id}, []string{amount}, false)
+}
+
+// BurnBatch destroys amounts[i] of the client's tokens of ids[i].
+func (m *MultiTokenContract) BurnBatch(ctx kalpsdk.TransactionContextInterface, ids []string, amounts []string) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	return m.transfer(ctx, owner, "", ids, amounts, true)
+}
+
+// SafeTransferFrom moves amount tokens of id from from to to. The client
+// must be from or its operator.
+func (m *MultiTokenContract) SafeTransferFrom(ctx kalpsdk.TransactionContextInterface, from string, to string, id string, amount string) error {
+	return m.transfer(ctx, from, to, []string{id}, []string{amount}, false)
+}
+
+// SafeBatchTransferFrom moves amounts[i] tokens of ids[i] from from to to,
+// all or none. The client must be from or its operator.
+func (m *MultiTokenContract) SafeBatchTransferFrom(ctx kalpsdk.TransactionContextInterface, from string, to string, ids []string, amounts []string) error {
+	return m.transfer(ctx, from, to, ids, amounts, true)
+}
+
+// BalanceOf returns the tokens of id held by account.
+func (m *MultiTokenContract) BalanceOf(ctx kalpsdk.TransactionContextInterface, account string, id string) (string, error) {
+	balance, err := getMultiTokenBalance(ctx, account, id)
+	if err != nil {
+		return "", err
+	}
+	return balance.String(), nil
+}
+
+// BalanceOfBatch returns the tokens of ids[i] held by accounts[i].
+func (m *MultiTokenContract) BalanceOfBatch(ctx kalpsdk.TransactionContextInterface, accounts []string, ids []string) ([]string, error) {
+	if len(accounts) != len(ids) {
+		return nil, fmt.Errorf("got %d accounts and %d ids", len(accounts), len(ids))
+	}
+	if len(ids) > maxMultiTokenBatch {
+		return nil, fmt.Errorf("a batch holds at most %d ids", maxMultiTokenBatch)
+	}
+	balances := make([]string, len(ids))
+	for i := range ids {
+		balance, err := getMultiTokenBalance(ctx, accounts[i], ids[i])
+		if err != nil {
+			return nil, err
+		}
+		balances[i] = balance.String()
+	}
+	return balances, nil
+}
+
+// TotalSupply returns the tokens of id in existence.
+func (m *MultiTokenContract) TotalSupply(ctx kalpsdk.TransactionContextInterface, id string) (string, error) {
+	key, err := ctx.CreateCompositeKey(multiTokenSupplyObjectType, []string{id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create supply key: %v", err)
+	}
+	supply, err := getAmount(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return supply.String(), nil
+}
+
+// TokenKind returns MultiTokenFungible or MultiTokenUnique for a minted id.
+func (m *MultiTokenContract) TokenKind(ctx kalpsdk.TransactionContextInterface, id string) (string, error) {
+	kind, err := getMultiTokenKind(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if kind == "" {
+		return "", fmt.Errorf("token %s was never minted", id)
+	}
+	return kind, nil
+}
+
+// SetApprovalForAll lets operator transfer all of the client's tokens, or
+// stops it.
+func (m *MultiTokenContract) SetApprovalForAll(ctx kalpsdk.TransactionContextInterface, operator string, approved bool) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if operator == "" || operator == owner {
+		return fmt.Errorf("operator must be another account")
+	}
+	key, err := ctx.CreateCompositeKey(multiTokenOperatorObjectType, []string{owner, operator})
+	if err != nil {
+		return fmt.Errorf("failed to create operator key: %v", err)
+	}
+	if approved {
+		err = ctx.PutStateWithoutKYC(key, []byte("true"))
+	} else {
+		err = ctx.DelStateWithoutKYC(key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store operator approval: %v", err)
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(ApprovalForAllEventName, ApprovalForAllEvent{Owner: owner, Operator: operator, Approved: approved})
+}
+
+// IsApprovedForAll reports whether operator may transfer all of owner's
+// tokens.
+func (m *MultiTokenContract) IsApprovedForAll(ctx kalpsdk.TransactionContextInterface, owner string, operator string) (bool, error) {
+	return isMultiTokenOperator(ctx, owner, operator)
+}
+
+// SetURI sets the metadata URI of id.
+func (m *MultiTokenContract) SetURI(ctx kalpsdk.TransactionContextInterface, id string, uri string) error {
+	if err := validateTokenID(id); err != nil {
+		return err
+	}
+	if uri == "" {
+		return fmt.Errorf("URI must not be empty")
+	}
+	key, err := ctx.CreateCompositeKey(multiTokenURIObjectType, []string{id})
+	if err != nil {
+		return fmt.Errorf("failed to create URI key: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, []byte(uri)); err != nil {
+		return fmt.Errorf("failed to store URI: %v", err)
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(URIEventName, URIEvent{ID: id, URI: uri})
+}
+
+// URI returns the metadata URI of id.
+func (m *MultiTokenContract) URI(ctx kalpsdk.TransactionContextInterface, id string) (string, error) {
+	key, err := ctx.CreateCompositeKey(multiTokenURIObjectType, []string{id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create URI key: %v", err)
+	}
+	uri, err := ctx.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read URI: %v", err)
+	}
+	if uri == nil {
+		return "", fmt.Errorf("token %s has no URI", id)
+	}
+	return string(uri), nil
+}
+
+// transfer moves amounts[i] tokens of ids[i] from from to to and raises one
+// TransferSingle or TransferBatch event. An empty from mints and an empty to
+// burns. Each ID's balance is checked and written in turn; a batch that fails
+// part way has already written the IDs before it, and is all or none only
+// because the failed transaction's writes are discarded.
+func (m *MultiTokenContract) transfer(ctx kalpsdk.TransactionContextInterface, from, to string, ids, amounts []string, batch bool) error {
+	operator, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if from == "" && to == "" {
+		return fmt.Errorf("recipient must not be empty")
+	}
+	if from == to {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+	if from != "" && from != operator {
+		ok, err := isMultiTokenOperator(ctx, from, operator)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is not %s or its operator", operator, from)
+		}
+	}
+	if len(ids) != len(amounts) {
+		return fmt.Errorf("got %d ids and %d amounts", len(ids), len(amounts))
+	}
+	if len(ids) == 0 || len(ids) > maxMultiTokenBatch {
+		return fmt.Errorf("a batch holds between 1 and %d ids", maxMultiTokenBatch)
+	}
+
+	// A transaction does not read its own writes, so the amounts of an ID
+	// that appears more than once are added up first.
+	var order []string
+	totals := make(map[string]*big.Int)
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		if err := validateTokenID(id); err != nil {
+			return err
+		}
+		value, err := parsePositiveAmount("amount", amounts[i])
+		if err != nil {
+			return err
+		}
+		values[i] = value.String()
+		if total, ok := totals[id]; ok {
+			total.Add(total, value)
+		} else {
+			totals[id] = value
+			order = append(order, id)
+		}
+	}
+
+	for _, id := range order {
+		value := totals[id]
+		if from != "" {
+			balance, err := getMultiTokenBalance(ctx, from, id)
+			if err != nil {
+				return err
+			}
+			if balance.Cmp(value) < 0 {
+				return fmt.Errorf("account %s has %s tokens of %s, %s are needed", from, balance, id, value)
+			}
+			if err := putMultiTokenBalance(ctx, from, id, balance.Sub(balance, value)); err != nil {
+				return err
+			}
+		}
+		if to != "" {
+			balance, err := getMultiTokenBalance(ctx, to, id)
+			if err != nil {
+				return err
+			}
+			if err := putMultiTokenBalance(ctx, to, id, balance.Add(balance, value)); err != nil {
+				return err
+			}
+		}
+		if from == "" || to == "" {
+			delta := new(big.Int).Set(value)
+			if to == "" {
+				delta.Neg(delta)
+			}
+			if err := addMultiTokenSupply(ctx, id, delta); err != nil {
+				return err
+			}
+		}
+	}
+
+	events := kalpext.NewEventBuffer(ctx)
+	if batch {
+		return events.Emit(TransferBatchEventName, TransferBatchEvent{Operator: operator, From: from, To: to, IDs: ids, Values: values})
+	}
+	return events.Emit(TransferSingleEventName, TransferSingleEvent{Operator: operator, From: from, To: to, ID: ids[0], Value: values[0]})
+}
+
+// fixMultiTokenKind records kind for the ids minted for the first time and
+// fails for any ID already minted as another kind, or already minted at all
+// when unique.
+func fixMultiTokenKind(ctx kalpsdk.TransactionContextInterface, ids []string, kind string) error {
+	for _, id := range ids {
+		if err := validateTokenID(id); err != nil {
+			return err
+		}
+		existing, err := getMultiTokenKind(ctx, id)
+		if err != nil {
+			return err
+		}
+		if existing == MultiTokenUnique {
+			return fmt.Errorf("token %s is unique and already minted", id)
+		}
+		if existing != "" && existing != kind {
+			return fmt.Errorf("token %s is %s, not %s", id, existing, kind)
+		}
+		if existing != "" {
+			continue
+		}
+		key, err := ctx.CreateCompositeKey(multiTokenKindObjectType, []string{id})
+		if err != nil {
+			return fmt.Errorf("failed to create token kind key: %v", err)
+		}
+		if err := ctx.PutStateWithoutKYC(key, []byte(kind)); err != nil {
+			return fmt.Errorf("failed to store token kind: %v", err)
+		}
+	}
+	return nil
+}
+
+func getMultiTokenKind(ctx kalpsdk.TransactionContextInterface, id string) (string, error) {
+	key, err := ctx.CreateCompositeKey(multiTokenKindObjectType, []string{id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create token kind key: %v", err)
+	}
+	kind, err := ctx.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token kind: %v", err)
+	}
+	return string(kind), nil
+}
+
+func isMultiTokenOperator(ctx kalpsdk.TransactionContextInterface, owner, operator string) (bool, error) {
+	key, err := ctx.CreateCompositeKey(multiTokenOperatorObjectType, []string{owner, operator})
+	if err != nil {
+		return false, fmt.Errorf("failed to create operator key: %v", err)
+	}
+	value, err := ctx.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read operator approval: %v", err)
+	}
+	return value != nil, nil
+}
+
+func addMultiTokenSupply(ctx kalpsdk.TransactionContextInterface, id string, delta *big.Int) error {
+	key, err := ctx.CreateCompositeKey(multiTokenSupplyObjectType, []string{id})
+	if err != nil {
+		return fmt.Errorf("failed to create supply key: %v", err)
+	}
+	supply, err := getAmount(ctx, key)
+	if err != nil {
+		return err
+	}
+	supply.Add(supply, delta)
+	if supply.Sign() < 0 {
+		return fmt.Errorf("supply of %s cannot become negative", id)
+	}
+	return putAmount(ctx, key, supply)
+}
+
+func getMultiTokenBalance(ctx kalpsdk.TransactionContextInterface, account, id string) (*big.Int, error) {
+	key, err := ctx.CreateCompositeKey(multiTokenBalanceObjectType, []string{account, id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create balance key: %v", err)
+	}
+	return getAmount(ctx, key)
+}
+
+func putMultiTokenBalance(ctx kalpsdk.TransactionContextInterface, account, id string, balance *big.Int) error {
+	key, err := ctx.CreateCompositeKey(multiTokenBalanceObjectType, []string{account, id})
+	if err != nil {
+		return fmt.Errorf("failed to create balance key: %v", err)
+	}
+	return putAmount(ctx, key, balance)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// NFTContractName is the name the NFT contract is served under.
+const NFTContractName = "nft"
+
+const (
+	// MinterRole can mint NFTs.
+	MinterRole = "MINTER"
+
+	// nftDocType is the docType of NFT records. The SDK's minted check
+	// queries records by id and docType.
+	nftDocType = "nft"
+
+	// nftObjectType is the composite key type of NFTs, keyed by token ID.
+	nftObjectType = "nft"
+
+	// nftOwnerObjectType is the composite key type of the tokens an account
+	// owns, keyed by owner and token ID.
+	nftOwnerObjectType = "nftOwner"
+
+	// nftBalanceObjectType is the composite key type of the number of
+	// tokens an account owns, keyed by owner.
+	nftBalanceObjectType = "nftBalance"
+
+	// nftOperatorObjectType is the composite key type of operator
+	// approvals, keyed by owner and operator.
+	nftOperatorObjectType = "nftOperator"
+
+	// maxTokenIDLength caps the length of a token ID in characters.
+	maxTokenIDLength = 128
+)
+
+// ApprovalForAllEventName is the name of the event raised when an operator
+// is approved or removed.
+const ApprovalForAllEventName = "ApprovalForAll"
+
+// nftRoleRequirements lists the NFT transactions that need a role.
+var nftRoleRequirements = kalpext.RoleRequirements{
+	"Mint": MinterRole,
+}
+
+// NFTContract is a KRC-721 non-fungible token.
+type NFTContract struct {
+	kalpsdk.Contract
+}
+
+// NFT is one non-fungible token. Approved may transfer it on behalf of
+// Owner until it is transferred.
+type NFT struct {
+	ID       string `json:"id"`
+	DocType  string `json:"docType"`
+	Owner    string `json:"owner"`
+	TokenURI string `json:"tokenURI"`
+	Approved string `json:"approved,omitempty" metadata:",optional"`
+}
+
+// NFTTransferEvent is the payload of a Transfer event. From is empty for
+// mints and To for burns.
+type NFTTransferEvent struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	TokenID string `json:"tokenId"`
+}
+
+// NFTApprovalEvent is the payload of an Approval event. Approved is empty
+// when an approval is cleared.
+type NFTApprovalEvent struct {
+	Owner    string `json:"owner"`
+	Approved string `json:"approved"`
+	TokenID  string `json:"tokenId"`
+}
+
+// ApprovalForAllEvent is the payload of an ApprovalForAll event.
+type ApprovalForAllEvent struct {
+	Owner    string `json:"owner"`
+	Operator string `json:"operator"`
+	Approved bool   `json:"approved"`
+}
+
+// Mint creates the token tokenID for the client with tokenURI as its
+// metadata URI.
+func (n *NFTContract) Mint(ctx kalpsdk.TransactionContextInterface, tokenID string, tokenURI string) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if err := validateTokenID(tokenID); err != nil {
+		return err
+	}
+	if tokenURI == "" {
+		return fmt.Errorf("token URI must not be empty")
+	}
+	if err := ctx.ValidateCreateTokenTransaction(tokenID, nftDocType, []string{owner}); err != nil {
+		return err
+	}
+	// The SDK's minted check is a rich query, which is not re-checked at
+	// commit. Reading the key also catches a concurrent mint of the same ID.
+	existing, err := getNFT(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("the token with ID '%v' is already minted", tokenID)
+	}
+	nft := &NFT{ID: tokenID, DocType: nftDocType, TokenURI: tokenURI}
+	return moveNFT(ctx, kalpext.NewEventBuffer(ctx), nft, owner)
+}
+
+// Burn destroys tokenID. The client must own it or be approved for it.
+func (n *NFTContract) Burn(ctx kalpsdk.TransactionContextInterface, tokenID string) error {
+	nft, err := requireNFT(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if err := requireNFTSpender(ctx, nft); err != nil {
+		return err
+	}
+	return moveNFT(ctx, kalpext.NewEventBuffer(ctx), nft, "")
+}
+
+// TransferFrom moves tokenID from from to to. The client must own the token
+// or be approved for it.
+func (n *NFTContract) TransferFrom(ctx kalpsdk.TransactionContextInterface, from string, to string, tokenID string) error {
+	nft, err := requireNFT(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if nft.Owner != from {
+		return fmt.Errorf("token %s is not owned by %s", tokenID, from)
+	}
+	if to == "" {
+		return fmt.Errorf("recipient must not be empty")
+	}
+	if to == from {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+	if err := requireNFTSpender(ctx, nft); err != nil {
+		return err
+	}
+	return moveNFT(ctx, kalpext.NewEventBuffer(ctx), nft, to)
+}
+
+// OwnerOf returns the owner of tokenID.
+func (n *NFTContract) OwnerOf(ctx kalpsdk.TransactionContextInterface, tokenID string) (string, error) {
+	nft, err := requireNFT(ctx, tokenID)
+	if err != nil {
+		return "", err
+	}
+	return nft.Owner, nil
+}
+
+// BalanceOf returns the number of tokens owner holds.
+func (n *NFTContract) BalanceOf(ctx kalpsdk.TransactionContextInterface, owner string) (int, error) {
+	key, err := ctx.CreateCompositeKey(nftBalanceObjectType, []string{owner})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create balance key: %v", err)
+	}
+	balance, err := getAmount(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return int(balance.Int64()), nil
+}
+
+// TotalSupply returns the number of tokens in existence. It counts the
+// tokens rather than keeping a count that every Mint and Burn would write.
+func (n *NFTContract) TotalSupply(ctx kalpsdk.TransactionContextInterface) (int, error) {
+	iterator, err := ctx.GetStateByPartialCompositeKey(nftObjectType, []string{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read tokens: %v", err)
+	}
+	defer iterator.Close()
+
+	supply := 0
+	for iterator.HasNext() {
+		if _, err := iterator.Next(); err != nil {
+			return 0, fmt.Errorf("failed to read tokens: %v", err)
+		}
+		supply++
+	}
+	return supply, nil
+}
+
+// TokensOfOwner returns the IDs of the tokens owner holds, in order.
+func (n *NFTContract) TokensOfOwner(ctx kalpsdk.TransactionContextInterface, owner string) ([]string, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("owner must not be empty")
+	}
+	iterator, err := ctx.GetStateByPartialCompositeKey(nftOwnerObjectType, []string{owner})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %v", err)
+	}
+	defer iterator.Close()
+
+	tokenIDs := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tokens: %v", err)
+		}
+		tokenIDs = append(tokenIDs, string(kv.Value))
+	}
+	return tokenIDs, nil
+}
+
+// TokenURI returns the metadata URI of tokenID.
+func (n *NFTContract) TokenURI(ctx kalpsdk.TransactionContextInterface, tokenID string) (string, error) {
+	nft, err := requireNFT(ctx, tokenID)
+	if err != nil {
+		return "", err
+	}
+	return nft.TokenURI, nil
+}
+
+// Approve lets approved transfer tokenID until it is next transferred. An
+// empty approved clears the approval. The client must own the token or be
+// its owner's operator.
+func (n *NFTContract) Approve(ctx kalpsdk.TransactionContextInterface, approved string, tokenID string) error {
+	operator, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	nft, err := requireNFT(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if approved == nft.Owner {
+		return fmt.Errorf("cannot approve the owner of token %s", tokenID)
+	}
+	if operator != nft.Owner {
+		ok, err := isOperator(ctx, nft.Owner, operator)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is not the owner or an operator of token %s", operator, tokenID)
+		}
+	}
+	nft.Approved = approved
+	if err := putNFT(ctx, nft); err != nil {
+		return err
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(ApprovalEventName, NFTApprovalEvent{Owner: nft.Owner, Approved: approved, TokenID: tokenID})
+}
+
+// GetApproved returns the account approved for tokenID, empty for none.
+func (n *NFTContract) GetApproved(ctx kalpsdk.TransactionContextInterface, tokenID string) (string, error) {
+	nft, err := requireNFT(ctx, tokenID)
+	if err != nil {
+		return "", err
+	}
+	return nft.Approved, nil
+}
+
+// SetApprovalForAll lets operator transfer and approve all of the client's
+// tokens, or stops it.
+func (n *NFTContract) SetApprovalForAll(ctx kalpsdk.TransactionContextInterface, operator string, approved bool) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if operator == "" || operator == owner {
+		return fmt.Errorf("operator must be another account")
+	}
+	key, err := ctx.CreateCompositeKey(nftOperatorObjectType, []string{owner, operator})
+	if err != nil {
+		return fmt.Errorf("failed to create operator key: %v", err)
+	}
+	if approved {
+		err = ctx.PutStateWithoutKYC(key, []byte("true"))
+	} else {
+		err = ctx.DelStateWithoutKYC(key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store operator approval: %v", err)
+	}
+	return kalpext.NewEventBuffer(ctx).Emit(ApprovalForAllEventName, ApprovalForAllEvent{Owner: owner, Operator: operator, Approved: approved})
+}
+
+// IsApprovedForAll reports whether operator may manage all of owner's
+// tokens.
+func (n *NFTContract) IsApprovedForAll(ctx kalpsdk.TransactionContextInterface, owner string, operator string) (bool, error) {
+	return isOperator(ctx, owner, operator)
+}
+
+// validateTokenID rejects token IDs that are empty, too long, or contain
+// control characters, quotes or backslashes, which the SDK's minted check
+// does not escape in its query.
+func validateTokenID(tokenID string) error {
+	if tokenID == "" {
+		return fmt.Errorf("token ID must not be empty")
+	}
+	if !utf8.ValidString(tokenID) || utf8.RuneCountInString(tokenID) > maxTokenIDLength {
+		return fmt.Errorf("token ID must be valid UTF-8 of at most %d characters", maxTokenIDLength)
+	}
+	if strings.IndexFunc(tokenID, func(r rune) bool { return unicode.IsControl(r) || r == '"' || r == '\\' }) >= 0 {
+		return fmt.Errorf("token ID must not contain control characters, quotes or backslashes")
+	}
+	return nil
+}
+
+// requireNFTSpender returns an error unless the client owns nft, is approved
+// for it, or is its owner's operator.
+func requireNFTSpender(ctx kalpsdk.TransactionContextInterface, nft *NFT) error {
+	spender, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	if spender == nft.Owner || spender == nft.Approved {
+		return nil
+	}
+	ok, err := isOperator(ctx, nft.Owner, spender)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is not the owner of token %s or approved for it", spender, nft.ID)
+	}
+	return nil
+}
+
+func isOperator(ctx kalpsdk.TransactionContextInterface, owner, operator string) (bool, error) {
+	key, err := ctx.CreateCompositeKey(nftOperatorObjectType, []string{owner, operator})
+	if err != nil {
+		return false, fmt.Errorf("failed to create operator key: %v", err)
+	}
+	value, err := ctx.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read operator approval: %v", err)
+	}
+	return value != nil, nil
+}
+
+// moveNFT gives nft to to, clearing its approval, and raises a Transfer
+// event in events. A token without owner is minted and an empty to burns it.
+func moveNFT(ctx kalpsdk.TransactionContextInterface, events *kalpext.EventBuffer, nft *NFT, to string) error {
+	from := nft.Owner
+	if from != "" {
+		if err := setNFTOwnership(ctx, from, nft.ID, false); err != nil {
+			return err
+		}
+	}
+	if to == "" {
+		key, err := ctx.CreateCompositeKey(nftObjectType, []string{nft.ID})
+		if err != nil {
+			return fmt.Errorf("failed to create token key: %v", err)
+		}
+		if err := ctx.DelStateWithoutKYC(key); err != nil {
+			return fmt.Errorf("failed to delete token: %v", err)
+		}
+	} else {
+		if err := setNFTOwnership(ctx, to, nft.ID, true); err != nil {
+			return err
+		}
+		nft.Owner = to
+		nft.Approved = ""
+		if err := putNFT(ctx, nft); err != nil {
+			return err
+		}
+	}
+	return events.Emit(TransferEventName, NFTTransferEvent{From: from, To: to, TokenID: nft.ID})
+}
+
+// setNFTOwnership adds tokenID to or removes it from the tokens of owner.
+func setNFTOwnership(ctx kalpsdk.TransactionContextInterface, owner, tokenID string, owned bool) error {
+	key, err := ctx.CreateCompositeKey(nftOwnerObjectType, []string{owner, tokenID})
+	if err != nil {
+		return fmt.Errorf("failed to create ownership key: %v", err)
+	}
+	delta := int64(-1)
+	if owned {
+		delta = 1
+		err = ctx.PutStateWithoutKYC(key, []byte(tokenID))
+	} else {
+		err = ctx.DelStateWithoutKYC(key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store ownership: %v", err)
+	}
+	balanceKey, err := ctx.CreateCompositeKey(nftBalanceObjectType, []string{owner})
+	if err != nil {
+		return fmt.Errorf("failed to create balance key: %v", err)
+	}
+	balance, err := getAmount(ctx, balanceKey)
+	if err != nil {
+		return err
+	}
+	return putAmount(ctx, balanceKey, balance.Add(balance, big.NewInt(delta)))
+}
+
+func requireNFT(ctx kalpsdk.TransactionContextInterface, tokenID string) (*NFT, error) {
+	nft, err := getNFT(ctx, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if nft == nil {
+		return nil, fmt.Errorf("token %s does not exist", tokenID)
+	}
+	return nft, nil
+}
+
+func getNFT(ctx kalpsdk.TransactionContextInterface, tokenID string) (*NFT, error) {
+	key, err := ctx.CreateCompositeKey(nftObjectType, []string{tokenID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token key: %v", err)
+	}
+	nftBytes, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token: %v", err)
+	}
+	if nftBytes == nil {
+		return nil, nil
+	}
+	var nft NFT
+	if err := json.Unmarshal(nftBytes, &nft); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token: %v", err)
+	}
+	return &nft, nil
+}
+
+func putNFT(ctx kalpsdk.TransactionContextInterface, nft *NFT) error {
+	key, err := ctx.CreateCompositeKey(nftObjectType, []string{nft.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create token key: %v", err)
+	}
+	nftBytes, err := json.Marshal(nft)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, nftBytes); err != nil {
+		return fmt.Errorf("failed to store token: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+const (
+	// paymentPolicyKey holds the PaymentPolicy set by SetPaymentPolicy.
+	paymentPolicyKey = "paymentPolicy"
+
+	// paymentReferenceObjectType is the composite key type recording the
+	// payments already spent, keyed by gateway and payment transaction ID,
+	// holding the ID of the transaction that spent them.
+	paymentReferenceObjectType = "paymentReference"
+
+	// paymentInfoDocType is the DocType of payment records, the same the
+	// kalpsdk payable contract writes.
+	paymentInfoDocType = "PAYMENT-INFO"
+
+	// greetingDocType is the AssetDocType of payments for a greeting.
+	greetingDocType = "greeting"
+)
+
+// PaymentPolicy configures paid greetings. While Required is set,
+// SetGreeting is rejected and greetings are set with SetPaidGreeting and a
+// payment of at least MinAmount in CurrencyCode.
+type PaymentPolicy struct {
+	Required     bool    `json:"required"`
+	MinAmount    float64 `json:"minAmount"`
+	CurrencyCode string  `json:"currencyCode"`
+}
+
+// GetPaymentPolicy returns the payment required to set a greeting.
+func (s *SmartContract) GetPaymentPolicy(ctx kalpsdk.TransactionContextInterface) (*PaymentPolicy, error) {
+	policyBytes, err := ctx.GetState(paymentPolicyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment policy: %v", err)
+	}
+	if policyBytes == nil {
+		return &PaymentPolicy{}, nil
+	}
+	var policy PaymentPolicy
+	if err := json.Unmarshal(policyBytes, &policy); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payment policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// SetPaymentPolicy turns paid greetings on or off. minAmount and currencyCode
+// are only checked while required is set.
+func (s *SmartContract) SetPaymentPolicy(ctx kalpsdk.TransactionContextInterface, required bool, minAmount float64, currencyCode string) error {
+	policy := PaymentPolicy{Required: required}
+	if required {
+		// kalpsdk rejects payments below 1 in CheckPaymentDetails.
+		if minAmount < 1 {
+			return fmt.Errorf("minimum amount must be at least 1")
+		}
+		currencyCode = strings.ToUpper(strings.TrimSpace(currencyCode))
+		if currencyCode == "" {
+			return fmt.Errorf("currency code must not be empty")
+		}
+		policy.MinAmount = minAmount
+		policy.CurrencyCode = currencyCode
+	}
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment policy: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(paymentPolicyKey, policyBytes); err != nil {
+		return fmt.Errorf("failed to store payment policy: %v", err)
+	}
+	return nil
+}
+
+// SetPaidGreeting sets a greeting like SetGreeting while paid greetings are
+// required. payment is the kalpsdk PaymentTracker JSON of the payment made
+// for it. The payment is recorded under the transaction ID with its AssetId
+// and AssetDocType pointing at the greeting, and can be spent only once. Like
+// the greeting, it is written with KYC only in WriteModeKYC.
+func (s *SmartContract) SetPaidGreeting(ctx kalpsdk.TransactionContextInterface, greeting string, payment string) error {
+	policy, err := s.GetPaymentPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if !policy.Required {
+		return fmt.Errorf("greetings are free, use SetGreeting")
+	}
+	var tracker kalpsdk.PaymentTracker
+	if err := json.Unmarshal([]byte(payment), &tracker); err != nil {
+		return fmt.Errorf("failed to unmarshal payment: %v", err)
+	}
+	if err := s.checkPayment(policy, tracker); err != nil {
+		return err
+	}
+	if err := spendPayment(ctx, tracker); err != nil {
+		return err
+	}
+
+	record, err := s.setGreeting(ctx, greeting)
+	if err != nil {
+		return err
+	}
+
+	tracker.TransactionId = ctx.GetTxID()
+	tracker.DocType = paymentInfoDocType
+	tracker.AssetId = record.TxID
+	tracker.AssetDocType = greetingDocType
+	trackerBytes, err := json.Marshal(tracker)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment: %v", err)
+	}
+	put, err := greetingWriter(ctx)
+	if err != nil {
+		return err
+	}
+	if err := put(tracker.TransactionId, trackerBytes); err != nil {
+		return fmt.Errorf("failed to store payment: %v", err)
+	}
+	return nil
+}
+
+// Payment is a payment record as returned by GetPayment. The stored record
+// is a kalpsdk PaymentTracker, whose JSON tags are not usable in metadata.
+type Payment struct {
+	TransactionID        string  `json:"transactionId"`
+	PaymentTransactionID string  `json:"paymentTransactionId"`
+	PaymentGatewayName   string  `json:"paymentGatewayName"`
+	Amount               float64 `json:"amount"`
+	CurrencyCode         string  `json:"currencyCode"`
+	AssetID              string  `json:"assetId"`
+	AssetDocType         string  `json:"assetDocType"`
+}
+
+// GetPayment returns the payment recorded by the transaction txID, for a paid
+// greeting the greeting's TxID.
+func (s *SmartContract) GetPayment(ctx kalpsdk.TransactionContextInterface, txID string) (*Payment, error) {
+	trackerBytes, err := ctx.GetState(txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment: %v", err)
+	}
+	if trackerBytes == nil {
+		return nil, fmt.Errorf("payment %s not found", txID)
+	}
+	var tracker kalpsdk.PaymentTracker
+	if err := json.Unmarshal(trackerBytes, &tracker); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payment: %v", err)
+	}
+	if tracker.DocType != paymentInfoDocType {
+		return nil, fmt.Errorf("payment %s not found", txID)
+	}
+	return &Payment{
+		TransactionID:        tracker.TransactionId,
+		PaymentTransactionID: tracker.PaymentTransactionID,
+		PaymentGatewayName:   tracker.PaymentGatewayName,
+		Amount:               tracker.PaymentMetaData.Amount,
+		CurrencyCode:         tracker.PaymentMetaData.CurrencyCode,
+		AssetID:              tracker.AssetId,
+		AssetDocType:         tracker.AssetDocType,
+	}, nil
+}
+
+func (s *SmartContract) checkPayment(policy *PaymentPolicy, tracker kalpsdk.PaymentTracker) error {
+	if !s.CheckPaymentDetails(tracker) {
+		return fmt.Errorf("payment does not have a valid amount or currency code")
+	}
+	if tracker.PaymentTransactionID == "" {
+		return fmt.Errorf("payment transaction id must not be empty")
+	}
+	if !strings.EqualFold(tracker.PaymentMetaData.CurrencyCode, policy.CurrencyCode) {
+		return fmt.Errorf("payment must be in %s, got %s", policy.CurrencyCode, tracker.PaymentMetaData.CurrencyCode)
+	}
+	if tracker.PaymentMetaData.Amount < policy.MinAmount {
+		return fmt.Errorf("payment of %g %s is below the minimum of %g", tracker.PaymentMetaData.Amount, policy.CurrencyCode, policy.MinAmount)
+	}
+	return nil
+}
+
+// spendPayment records that the payment has bought something, or returns an
+// error when it already has.
+func spendPayment(ctx kalpsdk.TransactionContextInterface, tracker kalpsdk.PaymentTracker) error {
+	key, err := ctx.CreateCompositeKey(paymentReferenceObjectType, []string{tracker.PaymentGatewayName, tracker.PaymentTransactionID})
+	if err != nil {
+		return fmt.Errorf("failed to create payment reference key: %v", err)
+	}
+	spentBy, err := ctx.GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read payment reference: %v", err)
+	}
+	if spentBy != nil {
+		return fmt.Errorf("payment %s was already used by transaction %s", tracker.PaymentTransactionID, spentBy)
+	}
+	if err := ctx.PutStateWithoutKYC(key, []byte(ctx.GetTxID())); err != nil {
+		return fmt.Errorf("failed to store payment reference: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+const (
+	// reactionObjectType is the composite key type of reactions, keyed by
+	// the transaction ID of the greeting and the user ID of the reacting
+	// user. Each reaction is its own key, so concurrent reactions never
+	// write the same key and never conflict.
+	reactionObjectType = "reaction"
+
+	// LikeReaction is the reaction that is not an emoji.
+	LikeReaction = "like"
+
+	// maxReactionLength caps the length of an emoji reaction in characters,
+	// enough for sequences joined with zero width joiners.
+	maxReactionLength = 8
+)
+
+// ReactionSummary counts the reactions to a greeting by reaction.
+type ReactionSummary struct {
+	GreetingID string         `json:"greetingId"`
+	Counts     map[string]int `json:"counts"`
+	Total      int            `json:"total"`
+}
+
+// React records the client's reaction, "like" or an emoji, to the greeting
+// with transaction ID greetingID, which must be the current greeting of
+// author. Reacting again replaces the client's earlier reaction, so every
+// user counts once per greeting.
+func (s *SmartContract) React(ctx kalpsdk.TransactionContextInterface, author string, greetingID string, reaction string) error {
+	if err := validateReaction(reaction); err != nil {
+		return err
+	}
+	greeting, err := s.GetUserGreeting(ctx, author)
+	if err != nil {
+		return err
+	}
+	if greeting.TxID != greetingID {
+		return fmt.Errorf("greeting %s is not the current greeting of user %s", greetingID, author)
+	}
+	key, err := callerReactionKey(ctx, greetingID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.PutStateWithoutKYC(key, []byte(reaction)); err != nil {
+		return fmt.Errorf("failed to store reaction: %v", err)
+	}
+	return nil
+}
+
+// RemoveReaction removes the client's reaction to a greeting.
+func (s *SmartContract) RemoveReaction(ctx kalpsdk.TransactionContextInterface, greetingID string) error {
+	key, err := callerReactionKey(ctx, greetingID)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read reaction: %v", err)
+	}
+	if existing == nil {
+		return fmt.Errorf("no reaction to greeting %s", greetingID)
+	}
+	if err := ctx.DelStateWithoutKYC(key); err != nil {
+		return fmt.Errorf("failed to remove reaction: %v", err)
+	}
+	return nil
+}
+
+// GetReactions counts the reactions to a greeting. The count is aggregated
+// from the reaction keys on every call.
+func (s *SmartContract) GetReactions(ctx kalpsdk.TransactionContextInterface, greetingID string) (*ReactionSummary, error) {
+	if greetingID == "" {
+		return nil, fmt.Errorf("greeting id must not be empty")
+	}
+	iterator, err := ctx.GetStateByPartialCompositeKey(reactionObjectType, []string{greetingID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reactions: %v", err)
+	}
+	defer iterator.Close()
+
+	summary := &ReactionSummary{GreetingID: greetingID, Counts: map[string]int{}}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reactions: %v", err)
+		}
+		summary.Counts[string(kv.Value)]++
+		summary.Total++
+	}
+	return summary, nil
+}
+
+// HasReacted reports whether the client has reacted to a greeting.
+func (s *SmartContract) HasReacted(ctx kalpsdk.TransactionContextInterface, greetingID string) (bool, error) {
+	key, err := callerReactionKey(ctx, greetingID)
+	if err != nil {
+		return false, err
+	}
+	reaction, err := ctx.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read reaction: %v", err)
+	}
+	return reaction != nil, nil
+}
+
+func callerReactionKey(ctx kalpsdk.TransactionContextInterface, greetingID string) (string, error) {
+	if greetingID == "" {
+		return "", fmt.Errorf("greeting id must not be empty")
+	}
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client id: %v", err)
+	}
+	key, err := ctx.CreateCompositeKey(reactionObjectType, []string{greetingID, userID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create reaction key: %v", err)
+	}
+	return key, nil
+}
+
+// validateReaction accepts "like" or a short emoji, including sequences with
+// modifiers, variation selectors and zero width joiners.
+func validateReaction(reaction string) error {
+	if reaction == LikeReaction {
+		return nil
+	}
+	if reaction == "" {
+		return fmt.Errorf("reaction must not be empty")
+	}
+	if !utf8.ValidString(reaction) {
+		return fmt.Errorf("reaction is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(reaction) > maxReactionLength {
+		return fmt.Errorf("reaction is longer than %d characters", maxReactionLength)
+	}
+	for _, r := range reaction {
+		if !unicode.In(r, unicode.So, unicode.Sk) && r != '\u200d' && !unicode.Is(unicode.Variation_Selector, r) {
+			return fmt.Errorf("reaction must be %q or an emoji", LikeReaction)
+		}
+	}
+	return nil
+}
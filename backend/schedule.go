@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+	"krc20/kalpext"
+)
+
+// scheduledGreetingObjectType is the composite key type of scheduled
+// greetings, keyed by the ID of the transaction that scheduled them.
+const scheduledGreetingObjectType = "scheduledGreeting"
+
+// ScheduledGreeting is a greeting shown by GetGreeting only between
+// ValidFrom and ValidUntil, RFC 3339 times in UTC. An empty bound leaves that
+// side open.
+type ScheduledGreeting struct {
+	ID         string    `json:"id"`
+	Text       string    `json:"text"`
+	Author     string    `json:"author"`
+	Timestamp  time.Time `json:"timestamp"`
+	ValidFrom  string    `json:"validFrom,omitempty" metadata:",optional"`
+	ValidUntil string    `json:"validUntil,omitempty" metadata:",optional"`
+}
+
+// ScheduleListing sorts the scheduled greetings relative to the transaction
+// timestamp. Each list is ordered by ValidFrom.
+type ScheduleListing struct {
+	Active   []ScheduledGreeting `json:"active"`
+	Upcoming []ScheduledGreeting `json:"upcoming"`
+	Expired  []ScheduledGreeting `json:"expired"`
+}
+
+// ScheduleGreeting adds a greeting that GetGreeting returns from validFrom
+// until validUntil, both RFC 3339 times. An empty validFrom makes it active
+// immediately and an empty validUntil keeps it active indefinitely. It
+// returns the ID of the scheduled greeting and raises a GreetingChanged event
+// carrying the bounds. Expired greetings are deleted, so the schedule
+// GetGreeting reads holds only the active and upcoming ones.
+func (s *SmartContract) ScheduleGreeting(ctx kalpsdk.TransactionContextInterface, greeting string, validFrom string, validUntil string) (string, error) {
+	greeting, err := s.validateGreeting(ctx, greeting)
+	if err != nil {
+		return "", err
+	}
+	record, err := newGreeting(ctx, greeting)
+	if err != nil {
+		return "", err
+	}
+	scheduled := ScheduledGreeting{
+		ID:        record.TxID,
+		Text:      record.Text,
+		Author:    record.Author,
+		Timestamp: record.Timestamp,
+	}
+	from, err := parseOptionalTime("validFrom", validFrom)
+	if err != nil {
+		return "", err
+	}
+	until, err := parseOptionalTime("validUntil", validUntil)
+	if err != nil {
+		return "", err
+	}
+	if !until.IsZero() {
+		if !until.After(record.Timestamp) {
+			return "", fmt.Errorf("validUntil must be in the future")
+		}
+		if !from.IsZero() && !until.After(from) {
+			return "", fmt.Errorf("validUntil must be after validFrom")
+		}
+	}
+	scheduled.ValidFrom = formatOptionalTime(from)
+	scheduled.ValidUntil = formatOptionalTime(until)
+
+	put, err := greetingWriter(ctx)
+	if err != nil {
+		return "", err
+	}
+	listing, err := listScheduledGreetings(ctx)
+	if err != nil {
+		return "", err
+	}
+	oldText := ""
+	if len(listing.Active) > 0 {
+		oldText = listing.Active[len(listing.Active)-1].Text
+	} else {
+		latestBytes, err := ctx.GetState(latestGreetingKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to read greeting: %v", err)
+		}
+		if latestBytes != nil {
+			latest, err := decodeGreeting(latestBytes)
+			if err != nil {
+				return "", err
+			}
+			oldText = latest.Text
+		}
+	}
+	for _, expired := range listing.Expired {
+		key, err := ctx.CreateCompositeKey(scheduledGreetingObjectType, []string{expired.ID})
+		if err != nil {
+			return "", fmt.Errorf("failed to create scheduled greeting key: %v", err)
+		}
+		if err := ctx.DelStateWithoutKYC(key); err != nil {
+			return "", fmt.Errorf("failed to delete expired scheduled greeting: %v", err)
+		}
+	}
+
+	key, err := ctx.CreateCompositeKey(scheduledGreetingObjectType, []string{scheduled.ID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create scheduled greeting key: %v", err)
+	}
+	scheduledBytes, err := json.Marshal(scheduled)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal scheduled greeting: %v", err)
+	}
+	if err := put(key, scheduledBytes); err != nil {
+		return "", fmt.Errorf("failed to store scheduled greeting: %v", err)
+	}
+
+	events := kalpext.NewEventBuffer(ctx)
+	return scheduled.ID, events.Emit(GreetingChangedEventName, GreetingChangedEvent{
+		SchemaVersion: greetingChangedSchemaVersion,
+		Old:           oldText,
+		New:           scheduled.Text,
+		Author:        scheduled.Author,
+		TxID:          scheduled.ID,
+		Timestamp:     scheduled.Timestamp,
+		ValidFrom:     scheduled.ValidFrom,
+		ValidUntil:    scheduled.ValidUntil,
+	})
+}
+
+// ListScheduledGreetings returns the scheduled greetings that are active,
+// upcoming and expired at the transaction timestamp.
+func (s *SmartContract) ListScheduledGreetings(ctx kalpsdk.TransactionContextInterface) (*ScheduleListing, error) {
+	return listScheduledGreetings(ctx)
+}
+
+func listScheduledGreetings(ctx kalpsdk.TransactionContextInterface) (*ScheduleListing, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all, err := getScheduledGreetings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listing := &ScheduleListing{
+		Active:   []ScheduledGreeting{},
+		Upcoming: []ScheduledGreeting{},
+		Expired:  []ScheduledGreeting{},
+	}
+	for _, scheduled := range all {
+		from, until, err := scheduled.bounds()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !from.IsZero() && now.Before(from):
+			listing.Upcoming = append(listing.Upcoming, scheduled)
+		case !until.IsZero() && !now.Before(until):
+			listing.Expired = append(listing.Expired, scheduled)
+		default:
+			listing.Active = append(listing.Active, scheduled)
+		}
+	}
+	return listing, nil
+}
+
+// activeScheduledGreeting returns the scheduled greeting GetGreeting shows at
+// the transaction timestamp: of the active ones, the one that became active
+// last, or nil when none is active.
+func activeScheduledGreeting(ctx kalpsdk.TransactionContextInterface) (*ScheduledGreeting, error) {
+	listing, err := listScheduledGreetings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(listing.Active) == 0 {
+		return nil, nil
+	}
+	return &listing.Active[len(listing.Active)-1], nil
+}
+
+// getScheduledGreetings returns every scheduled greeting ordered by the time
+// it becomes active, then by the time it was scheduled.
+func getScheduledGreetings(ctx kalpsdk.TransactionContextInterface) ([]ScheduledGreeting, error) {
+	iterator, err := ctx.GetStateByPartialCompositeKey(scheduledGreetingObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduled greetings: %v", err)
+	}
+	defer iterator.Close()
+
+	var all []ScheduledGreeting
+	var activeFrom []time.Time
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scheduled greetings: %v", err)
+		}
+		var scheduled ScheduledGreeting
+		if err := json.Unmarshal(kv.Value, &scheduled); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal scheduled greeting: %v", err)
+		}
+		from, _, err := scheduled.bounds()
+		if err != nil {
+			return nil, err
+		}
+		if from.IsZero() {
+			from = scheduled.Timestamp
+		}
+		all = append(all, scheduled)
+		activeFrom = append(activeFrom, from)
+	}
+	sort.Sort(byActiveFrom{all, activeFrom})
+	return all, nil
+}
+
+// byActiveFrom sorts scheduled greetings by the time they become active,
+// ValidFrom or else the time they were scheduled, then by the time they were
+// scheduled and their ID.
+type byActiveFrom struct {
+	greetings []ScheduledGreeting
+	from      []time.Time
+}
+
+func (b byActiveFrom) Len() int { return len(b.greetings) }
+
+func (b byActiveFrom) Swap(i, j int) {
+	b.greetings[i], b.greetings[j] = b.greetings[j], b.greetings[i]
+	b.from[i], b.from[j] = b.from[j], b.from[i]
+}
+
+func (b byActiveFrom) Less(i, j int) bool {
+	all := b.greetings
+	if fi, fj := b.from[i], b.from[j]; !fi.Equal(fj) {
+		return fi.Before(fj)
+	}
+	if !all[i].Timestamp.Equal(all[j].Timestamp) {
+		return all[i].Timestamp.Before(all[j].Timestamp)
+	}
+	return all[i].ID < all[j].ID
+}
+
+// bounds returns ValidFrom and ValidUntil, zero when open.
+func (g ScheduledGreeting) bounds() (time.Time, time.Time, error) {
+	from, err := parseOptionalTime("validFrom", g.ValidFrom)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	until, err := parseOptionalTime("validUntil", g.ValidUntil)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, until, nil
+}
+
+// parseOptionalTime parses an RFC 3339 time. An empty value, such as an
+// open bound, returns the zero time.
+func parseOptionalTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return t.UTC(), nil
+}
+
+// formatOptionalTime formats t as RFC 3339, or the zero time as empty.
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// txTime returns the transaction timestamp, the only clock every endorser
+// agrees on.
+func txTime(ctx kalpsdk.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime().UTC(), nil
+}
@@ -0,0 +1,17 @@
+//go:build sim
+
+package main
+
+import (
+	"os"
+
+	"krc20/ledgersim"
+)
+
+// Building with the sim tag replaces the chaincode server with the ledgersim
+// command line, which runs the contracts against in-memory ledgers:
+//
+//	go run -tags sim . determinism fixtures/greeting.json
+func main() {
+	os.Exit(ledgersim.Main(newChaincode, os.Args[1:]))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+	"krc20/snapshot"
+)
+
+const (
+	// maxStatePageSize caps the records returned by one ExportState call.
+	maxStatePageSize = 500
+
+	// maxImportBatch caps the records written by one ImportState call, so
+	// that a batch stays well inside the peer's proposal size limits.
+	maxImportBatch = 100
+)
+
+// stateObjectTypes lists the object types of the composite keys the contract
+// writes. A range query cannot reach composite keys, so ExportState walks the
+// plain keys first and then each of these object types in order.
+var stateObjectTypes = []string{
+	greetingObjectType,
+	scheduledGreetingObjectType,
+	localizedGreetingObjectType,
+	reactionObjectType,
+	paymentReferenceObjectType,
+	balanceObjectType,
+	balanceCheckpointObjectType,
+	supplyCheckpointObjectType,
+	allowanceObjectType,
+	airdropObjectType,
+	airdropClaimedObjectType,
+	vestingScheduleObjectType,
+	stakeObjectType,
+	nftObjectType,
+	nftOwnerObjectType,
+	nftBalanceObjectType,
+	nftOperatorObjectType,
+	multiTokenBalanceObjectType,
+	multiTokenSupplyObjectType,
+	multiTokenOperatorObjectType,
+	multiTokenURIObjectType,
+	multiTokenKindObjectType,
+	escrowObjectType,
+	bannedTermObjectType,
+	kalpext.RoleObjectType,
+	kalpext.RoleAdminObjectType,
+}
+
+// StatePage is one page of the contract's world state. Bookmark is passed to
+// the next ExportState call and is empty on the last page.
+type StatePage struct {
+	Records  []snapshot.Record `json:"records"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// ExportState returns up to pageSize keys of the contract's world state,
+// plain and composite, starting after bookmark.
+func (s *SmartContract) ExportState(ctx kalpsdk.TransactionContextInterface, bookmark string, pageSize int) (*StatePage, error) {
+	if pageSize <= 0 || pageSize > maxStatePageSize {
+		pageSize = maxStatePageSize
+	}
+	page := &StatePage{Records: []snapshot.Record{}}
+
+	if !strings.HasPrefix(bookmark, "\x00") {
+		startKey := ""
+		if bookmark != "" {
+			// The smallest key after bookmark.
+			startKey = bookmark + "\x00"
+		}
+		iterator, err := ctx.GetStateByRange(startKey, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state: %v", err)
+		}
+		full, err := appendRecords(page, iterator, "", pageSize)
+		if err != nil || full {
+			return page, err
+		}
+		bookmark = ""
+	}
+
+	bookmarkType := ""
+	if bookmark != "" {
+		objectType, _, err := ctx.SplitCompositeKey(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark: %v", err)
+		}
+		bookmarkType = objectType
+	}
+	for _, objectType := range stateObjectTypes {
+		if bookmarkType != "" {
+			if objectType != bookmarkType {
+				continue
+			}
+			bookmarkType = ""
+		}
+		iterator, err := ctx.GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s state: %v", objectType, err)
+		}
+		full, err := appendRecords(page, iterator, bookmark, pageSize)
+		if err != nil || full {
+			return page, err
+		}
+		bookmark = ""
+	}
+	return page, nil
+}
+
+// appendRecords adds the keys after the key `after` from iterator to page
+// until the page holds pageSize records. It closes the iterator and reports
+// whether the page is full, in which case the bookmark is set.
+func appendRecords(page *StatePage, iterator kalpsdk.StateQueryIteratorInterface, after string, pageSize int) (bool, error) {
+	defer iterator.Close()
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return false, fmt.Errorf("failed to read state: %v", err)
+		}
+		if kv.Key <= after {
+			continue
+		}
+		if len(page.Records) == pageSize {
+			page.Bookmark = page.Records[len(page.Records)-1].Key
+			return true, nil
+		}
+		page.Records = append(page.Records, snapshot.NewRecord(kv.Key, kv.Value))
+	}
+	return false, nil
+}
+
+// ImportState writes a batch of at most maxImportBatch snapshot records and
+// returns the number of keys written. It needs the default admin role.
+// Ownership, roles and the paused flag are never imported, so a snapshot
+// cannot change who controls the contract.
+func (s *SmartContract) ImportState(ctx kalpsdk.TransactionContextInterface, records []snapshot.Record) (int, error) {
+	if len(records) == 0 {
+		return 0, fmt.Errorf("no records to import")
+	}
+	if len(records) > maxImportBatch {
+		return 0, fmt.Errorf("batch of %d records exceeds the limit of %d", len(records), maxImportBatch)
+	}
+
+	imported := 0
+	for i, record := range records {
+		if record.Key == "" {
+			return 0, fmt.Errorf("record %d has an empty key", i)
+		}
+		if isAccessControlKey(ctx, record.Key) {
+			s.Logger.Info("Skipping access control key in state import")
+			continue
+		}
+		value, err := record.Bytes()
+		if err != nil {
+			return 0, err
+		}
+		if err := ctx.PutStateWithoutKYC(record.Key, value); err != nil {
+			return 0, fmt.Errorf("failed to import key %q: %v", record.Key, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func isAccessControlKey(ctx kalpsdk.TransactionContextInterface, key string) bool {
+	if key == ownerKey || key == kalpext.PausedKey {
+		return true
+	}
+	if !strings.HasPrefix(key, "\x00") {
+		return false
+	}
+	objectType, _, err := ctx.SplitCompositeKey(key)
+	if err != nil {
+		return false
+	}
+	return objectType == kalpext.RoleObjectType || objectType == kalpext.RoleAdminObjectType
+}
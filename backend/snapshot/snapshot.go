@@ -0,0 +1,140 @@
+// Package snapshot reads and writes world-state snapshots.
+//
+// A snapshot is a JSON-lines file. The first line is a Header naming the
+// format and its version, every following line is a Record holding one key
+// and its value:
+//
+//	{"format":"kalp-world-state","version":1,"namespace":"greeting"}
+//	{"key":"greeting","value":"Hello, Kalp!"}
+//	{"key":"\u0000owner\u0000alice\u0000","valueBase64":"AAEC"}
+//
+// Composite keys are stored as they are in the state database, with their
+// separators escaped by JSON. Values that are valid UTF-8 are stored as text,
+// anything else as base64.
+package snapshot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	// Format identifies a world-state snapshot.
+	Format = "kalp-world-state"
+
+	// Version is the snapshot format version written by this package.
+	Version = 1
+)
+
+// Header is the first line of a snapshot.
+type Header struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Namespace string `json:"namespace,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+// Record is one key of a snapshot. At most one of Value and ValueBase64 is
+// set.
+type Record struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty" metadata:",optional"`
+	ValueBase64 string `json:"valueBase64,omitempty" metadata:",optional"`
+}
+
+// NewRecord returns the record for key holding value.
+func NewRecord(key string, value []byte) Record {
+	if utf8.Valid(value) {
+		return Record{Key: key, Value: string(value)}
+	}
+	return Record{Key: key, ValueBase64: base64.StdEncoding.EncodeToString(value)}
+}
+
+// Bytes returns the value held by the record.
+func (r Record) Bytes() ([]byte, error) {
+	if r.ValueBase64 == "" {
+		return []byte(r.Value), nil
+	}
+	if r.Value != "" {
+		return nil, fmt.Errorf("record %q has both value and valueBase64", r.Key)
+	}
+	value, err := base64.StdEncoding.DecodeString(r.ValueBase64)
+	if err != nil {
+		return nil, fmt.Errorf("record %q has invalid base64 value: %v", r.Key, err)
+	}
+	return value, nil
+}
+
+// Writer writes a snapshot.
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter writes the header to w and returns a Writer for the records.
+// Format and Version of the header are set by NewWriter.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	h.Format = Format
+	h.Version = Version
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(h); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot header: %v", err)
+	}
+	return &Writer{enc: enc}, nil
+}
+
+// Write writes one record.
+func (w *Writer) Write(r Record) error {
+	if r.Key == "" {
+		return errors.New("snapshot record has an empty key")
+	}
+	if err := w.enc.Encode(r); err != nil {
+		return fmt.Errorf("failed to write snapshot record %q: %v", r.Key, err)
+	}
+	return nil
+}
+
+// Reader reads a snapshot.
+type Reader struct {
+	Header Header
+
+	dec  *json.Decoder
+	line int
+}
+
+// NewReader reads and checks the header of the snapshot in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{dec: json.NewDecoder(r), line: 1}
+	if err := rd.dec.Decode(&rd.Header); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("snapshot is empty")
+		}
+		return nil, fmt.Errorf("failed to read snapshot header: %v", err)
+	}
+	if rd.Header.Format != Format {
+		return nil, fmt.Errorf("not a world-state snapshot: format %q", rd.Header.Format)
+	}
+	if rd.Header.Version < 1 || rd.Header.Version > Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected at most %d", rd.Header.Version, Version)
+	}
+	return rd, nil
+}
+
+// Next returns the next record, or io.EOF after the last one.
+func (r *Reader) Next() (Record, error) {
+	var rec Record
+	if err := r.dec.Decode(&rec); err != nil {
+		if err == io.EOF {
+			return Record{}, io.EOF
+		}
+		return Record{}, fmt.Errorf("snapshot line %d: %v", r.line+1, err)
+	}
+	r.line++
+	if rec.Key == "" {
+		return Record{}, fmt.Errorf("snapshot line %d: record has an empty key", r.line)
+	}
+	return rec, nil
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// StakingContractName is the name the staking contract is served under.
+const StakingContractName = "staking"
+
+const (
+	// stakingPoolKey holds the StakingPool.
+	stakingPoolKey = "stakingPool"
+
+	// stakeObjectType is the composite key type of stakes, keyed by account.
+	stakeObjectType = "stake"
+
+	// stakingAccount holds the staked tokens, including those cooling down.
+	stakingAccount = "staking/stakes"
+
+	// stakingRewardsAccount holds the rewards not yet claimed.
+	stakingRewardsAccount = "staking/rewards"
+)
+
+const (
+	// StakedEventName is the name of the event raised by Stake.
+	StakedEventName = "Staked"
+
+	// UnstakedEventName is the name of the event raised by Unstake.
+	UnstakedEventName = "Unstaked"
+
+	// WithdrawnEventName is the name of the event raised by Withdraw.
+	WithdrawnEventName = "Withdrawn"
+
+	// RewardPaidEventName is the name of the event raised by ClaimRewards.
+	RewardPaidEventName = "RewardPaid"
+
+	// RewardsFundedEventName is the name of the event raised by FundRewards.
+	RewardsFundedEventName = "RewardsFunded"
+)
+
+// rewardPrecision scales the reward rate and the reward per token, so that
+// rates below one token per second and large stakes keep their precision.
+var rewardPrecision = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// stakingRoleRequirements lists the staking transactions that need a role.
+var stakingRoleRequirements = kalpext.RoleRequirements{
+	"FundRewards":        kalpext.DefaultAdminRole,
+	"SetUnstakeCooldown": kalpext.DefaultAdminRole,
+}
+
+// StakingContract pays stakers rewards in proportion to their stake and the
+// time it was staked.
+//
+// Rewards accrue with a reward-per-token accumulator: the pool adds the
+// rewards paid to one staked token since its last update, and each stake
+// remembers the accumulator at its own last update. A staker's rewards are
+// their stake times the difference, so no transaction touches more than the
+// pool and one stake. While nothing is staked the reward period stands still,
+// so rewards funded then go to the stakers that follow.
+type StakingContract struct {
+	kalpsdk.Contract
+}
+
+// StakingPool is the state shared by all stakes. RewardRate and
+// RewardPerToken are scaled by 10^18. Rewards are paid at RewardRate until
+// PeriodFinish.
+type StakingPool struct {
+	TotalStaked     string `json:"totalStaked"`
+	RewardRate      string `json:"rewardRate"`
+	RewardPerToken  string `json:"rewardPerToken"`
+	LastUpdate      string `json:"lastUpdate,omitempty" metadata:",optional"`
+	PeriodFinish    string `json:"periodFinish,omitempty" metadata:",optional"`
+	CooldownSeconds int64  `json:"cooldownSeconds"`
+}
+
+// Stake is the stake of one account. Rewards holds the rewards earned up to
+// the pool's RewardPerToken at RewardPerTokenPaid. Unstaking tokens can be
+// withdrawn from UnlockAt.
+type Stake struct {
+	Account            string `json:"account"`
+	Amount             string `json:"amount"`
+	RewardPerTokenPaid string `json:"rewardPerTokenPaid"`
+	Rewards            string `json:"rewards"`
+	Unstaking          string `json:"unstaking,omitempty" metadata:",optional"`
+	UnlockAt           string `json:"unlockAt,omitempty" metadata:",optional"`
+}
+
+// StakingEvent is the payload of the events raised by stakers.
+type StakingEvent struct {
+	Account string `json:"account"`
+	Amount  string `json:"amount"`
+}
+
+// RewardsFundedEvent is the payload of a RewardsFunded event. RewardRate is
+// the new rate, scaled by 10^18, paid until PeriodFinish.
+type RewardsFundedEvent struct {
+	Amount          string `json:"amount"`
+	DurationSeconds int64  `json:"durationSeconds"`
+	RewardRate      string `json:"rewardRate"`
+	PeriodFinish    string `json:"periodFinish"`
+}
+
+// Stake moves amount of the client's tokens into its stake.
+func (s *StakingContract) Stake(ctx kalpsdk.TransactionContextInterface, amount string) error {
+	account, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	pool, stake, err := updateRewards(ctx, account)
+	if err != nil {
+		return err
+	}
+	if err := addToAmount(&stake.Amount, value); err != nil {
+		return err
+	}
+	if err := addToAmount(&pool.TotalStaked, value); err != nil {
+		return err
+	}
+	if err := putStakingState(ctx, pool, stake); err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, account, stakingAccount, value); err != nil {
+		return err
+	}
+	return events.Emit(StakedEventName, StakingEvent{Account: account, Amount: value.String()})
+}
+
+// Unstake removes amount from the client's stake. Without a cooldown the
+// tokens are returned at once. Otherwise they stop earning rewards and can
+// be withdrawn with Withdraw once the cooldown has passed; unstaking more
+// restarts the cooldown for all of them.
+func (s *StakingContract) Unstake(ctx kalpsdk.TransactionContextInterface, amount string) error {
+	account, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	pool, stake, err := updateRewards(ctx, account)
+	if err != nil {
+		return err
+	}
+	if err := addToAmount(&stake.Amount, new(big.Int).Neg(value)); err != nil {
+		return fmt.Errorf("cannot unstake %s, only %s is staked", value, stake.Amount)
+	}
+	if err := addToAmount(&pool.TotalStaked, new(big.Int).Neg(value)); err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if pool.CooldownSeconds == 0 {
+		if err := moveTokens(ctx, events, stakingAccount, account, value); err != nil {
+			return err
+		}
+	} else {
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+		if err := addToAmount(&stake.Unstaking, value); err != nil {
+			return err
+		}
+		stake.UnlockAt = formatOptionalTime(now.Add(time.Duration(pool.CooldownSeconds) * time.Second))
+	}
+	if err := putStakingState(ctx, pool, stake); err != nil {
+		return err
+	}
+	return events.Emit(UnstakedEventName, StakingEvent{Account: account, Amount: value.String()})
+}
+
+// Withdraw returns the client's unstaked tokens once their cooldown has
+// passed.
+func (s *StakingContract) Withdraw(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	account, err := clientID(ctx)
+	if err != nil {
+		return "", err
+	}
+	stake, err := getStake(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	if stake.Unstaking == "" {
+		return "", fmt.Errorf("no unstaked tokens to withdraw")
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	unlockAt, err := parseOptionalTime("unlockAt", stake.UnlockAt)
+	if err != nil {
+		return "", err
+	}
+	if now.Before(unlockAt) {
+		return "", fmt.Errorf("unstaked tokens unlock at %s", stake.UnlockAt)
+	}
+	value, err := parseAmount("unstaking", stake.Unstaking)
+	if err != nil {
+		return "", err
+	}
+	stake.Unstaking = ""
+	stake.UnlockAt = ""
+	if err := putStake(ctx, stake); err != nil {
+		return "", err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, stakingAccount, account, value); err != nil {
+		return "", err
+	}
+	if err := events.Emit(WithdrawnEventName, StakingEvent{Account: account, Amount: value.String()}); err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// ClaimRewards pays the client the rewards it has earned.
+func (s *StakingContract) ClaimRewards(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	account, err := clientID(ctx)
+	if err != nil {
+		return "", err
+	}
+	pool, stake, err := updateRewards(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	rewards, err := parseAmount("rewards", stake.Rewards)
+	if err != nil {
+		return "", err
+	}
+	if rewards.Sign() == 0 {
+		return "", fmt.Errorf("no rewards to claim")
+	}
+	stake.Rewards = "0"
+	if err := putStakingState(ctx, pool, stake); err != nil {
+		return "", err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, stakingRewardsAccount, account, rewards); err != nil {
+		return "", err
+	}
+	if err := events.Emit(RewardPaidEventName, StakingEvent{Account: account, Amount: rewards.String()}); err != nil {
+		return "", err
+	}
+	return rewards.String(), nil
+}
+
+// FundRewards moves amount of the client's tokens into the rewards and pays
+// them out evenly over the next durationSeconds. Rewards left from a running
+// period are added to the new one.
+func (s *StakingContract) FundRewards(ctx kalpsdk.TransactionContextInterface, amount string, durationSeconds int64) error {
+	admin, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	if durationSeconds <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	pool, _, err := updateRewards(ctx, "")
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	periodFinish, err := parseOptionalTime("periodFinish", pool.PeriodFinish)
+	if err != nil {
+		return err
+	}
+	rate, err := parseAmount("rewardRate", pool.RewardRate)
+	if err != nil {
+		return err
+	}
+	funds := new(big.Int).Mul(value, rewardPrecision)
+	if now.Before(periodFinish) {
+		remaining := big.NewInt(int64(periodFinish.Sub(now) / time.Second))
+		funds.Add(funds, remaining.Mul(remaining, rate))
+	}
+	pool.RewardRate = funds.Quo(funds, big.NewInt(durationSeconds)).String()
+	pool.LastUpdate = formatOptionalTime(now)
+	pool.PeriodFinish = formatOptionalTime(now.Add(time.Duration(durationSeconds) * time.Second))
+	if err := putStakingPool(ctx, pool); err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, admin, stakingRewardsAccount, value); err != nil {
+		return err
+	}
+	return events.Emit(RewardsFundedEventName, RewardsFundedEvent{
+		Amount:          value.String(),
+		DurationSeconds: durationSeconds,
+		RewardRate:      pool.RewardRate,
+		PeriodFinish:    pool.PeriodFinish,
+	})
+}
+
+// SetUnstakeCooldown sets how long unstaked tokens wait before Withdraw. It
+// applies to later Unstake calls.
+func (s *StakingContract) SetUnstakeCooldown(ctx kalpsdk.TransactionContextInterface, cooldownSeconds int64) error {
+	if cooldownSeconds < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	pool, err := getStakingPool(ctx)
+	if err != nil {
+		return err
+	}
+	pool.CooldownSeconds = cooldownSeconds
+	return putStakingPool(ctx, pool)
+}
+
+// GetStakingPool returns the pool with its reward per token brought up to the
+// transaction timestamp.
+func (s *StakingContract) GetStakingPool(ctx kalpsdk.TransactionContextInterface) (*StakingPool, error) {
+	pool, _, err := updateRewards(ctx, "")
+	return pool, err
+}
+
+// GetStake returns the stake of account with the rewards it has earned as of
+// the transaction timestamp.
+func (s *StakingContract) GetStake(ctx kalpsdk.TransactionContextInterface, account string) (*Stake, error) {
+	if account == "" {
+		return nil, fmt.Errorf("account must not be empty")
+	}
+	_, stake, err := updateRewards(ctx, account)
+	return stake, err
+}
+
+// EarnedRewards returns the rewards account can claim as of the transaction
+// timestamp.
+func (s *StakingContract) EarnedRewards(ctx kalpsdk.TransactionContextInterface, account string) (string, error) {
+	stake, err := s.GetStake(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	return stake.Rewards, nil
+}
+
+// updateRewards brings the pool's reward per token up to the transaction
+// timestamp and credits the rewards account earned since its last update.
+// While nothing is staked it moves the end of the period instead.
+// The stake is nil for an empty account. Neither is stored.
+func updateRewards(ctx kalpsdk.TransactionContextInterface, account string) (*StakingPool, *Stake, error) {
+	pool, err := getStakingPool(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	lastUpdate, err := parseOptionalTime("lastUpdate", pool.LastUpdate)
+	if err != nil {
+		return nil, nil, err
+	}
+	periodFinish, err := parseOptionalTime("periodFinish", pool.PeriodFinish)
+	if err != nil {
+		return nil, nil, err
+	}
+	rewardPerToken, err := parseAmount("rewardPerToken", pool.RewardPerToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	applicable := now
+	if periodFinish.Before(applicable) {
+		applicable = periodFinish
+	}
+	if applicable.After(lastUpdate) {
+		totalStaked, err := parseAmount("totalStaked", pool.TotalStaked)
+		if err != nil {
+			return nil, nil, err
+		}
+		rate, err := parseAmount("rewardRate", pool.RewardRate)
+		if err != nil {
+			return nil, nil, err
+		}
+		if totalStaked.Sign() > 0 {
+			accrued := big.NewInt(int64(applicable.Sub(lastUpdate) / time.Second))
+			accrued.Mul(accrued, rate)
+			rewardPerToken.Add(rewardPerToken, accrued.Quo(accrued, totalStaked))
+			pool.RewardPerToken = rewardPerToken.String()
+			pool.LastUpdate = formatOptionalTime(applicable)
+		} else {
+			// No one could earn the rewards since lastUpdate, so the
+			// period ends that much later instead.
+			pool.PeriodFinish = formatOptionalTime(periodFinish.Add(now.Sub(lastUpdate)))
+			pool.LastUpdate = formatOptionalTime(now)
+		}
+	}
+	if account == "" {
+		return pool, nil, nil
+	}
+
+	stake, err := getStake(ctx, account)
+	if err != nil {
+		return nil, nil, err
+	}
+	amount, err := parseAmount("amount", stake.Amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	paid, err := parseAmount("rewardPerTokenPaid", stake.RewardPerTokenPaid)
+	if err != nil {
+		return nil, nil, err
+	}
+	earned := amount.Mul(amount, paid.Sub(rewardPerToken, paid))
+	if err := addToAmount(&stake.Rewards, earned.Quo(earned, rewardPrecision)); err != nil {
+		return nil, nil, err
+	}
+	stake.RewardPerTokenPaid = rewardPerToken.String()
+	return pool, stake, nil
+}
+
+// addToAmount adds delta to the amount in *field, an empty field being zero.
+// It fails when the result would be negative.
+func addToAmount(field *string, delta *big.Int) error {
+	value := new(big.Int)
+	if *field != "" {
+		var err error
+		if value, err = parseAmount("amount", *field); err != nil {
+			return err
+		}
+	}
+	value.Add(value, delta)
+	if value.Sign() < 0 {
+		return fmt.Errorf("amount cannot become negative")
+	}
+	*field = value.String()
+	return nil
+}
+
+func getStakingPool(ctx kalpsdk.TransactionContextInterface) (*StakingPool, error) {
+	poolBytes, err := ctx.GetState(stakingPoolKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staking pool: %v", err)
+	}
+	if poolBytes == nil {
+		return &StakingPool{TotalStaked: "0", RewardRate: "0", RewardPerToken: "0"}, nil
+	}
+	var pool StakingPool
+	if err := json.Unmarshal(poolBytes, &pool); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal staking pool: %v", err)
+	}
+	return &pool, nil
+}
+
+func putStakingPool(ctx kalpsdk.TransactionContextInterface, pool *StakingPool) error {
+	poolBytes, err := json.Marshal(pool)
+	if err != nil {
+		return fmt.Errorf("failed to marshal staking pool: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(stakingPoolKey, poolBytes); err != nil {
+		return fmt.Errorf("failed to store staking pool: %v", err)
+	}
+	return nil
+}
+
+func getStake(ctx kalpsdk.TransactionContextInterface, account string) (*Stake, error) {
+	key, err := ctx.CreateCompositeKey(stakeObjectType, []string{account})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stake key: %v", err)
+	}
+	stakeBytes, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stake: %v", err)
+	}
+	if stakeBytes == nil {
+		return &Stake{Account: account, Amount: "0", RewardPerTokenPaid: "0", Rewards: "0"}, nil
+	}
+	var stake Stake
+	if err := json.Unmarshal(stakeBytes, &stake); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stake: %v", err)
+	}
+	return &stake, nil
+}
+
+// putStake stores stake, deleting it once it holds nothing.
+func putStake(ctx kalpsdk.TransactionContextInterface, stake *Stake) error {
+	key, err := ctx.CreateCompositeKey(stakeObjectType, []string{stake.Account})
+	if err != nil {
+		return fmt.Errorf("failed to create stake key: %v", err)
+	}
+	if stake.Amount == "0" && stake.Rewards == "0" && stake.Unstaking == "" {
+		if err := ctx.DelStateWithoutKYC(key); err != nil {
+			return fmt.Errorf("failed to delete stake: %v", err)
+		}
+		return nil
+	}
+	stakeBytes, err := json.Marshal(stake)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stake: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, stakeBytes); err != nil {
+		return fmt.Errorf("failed to store stake: %v", err)
+	}
+	return nil
+}
+
+func putStakingState(ctx kalpsdk.TransactionContextInterface, pool *StakingPool, stake *Stake) error {
+	if err := putStakingPool(ctx, pool); err != nil {
+		return err
+	}
+	return putStake(ctx, stake)
+}
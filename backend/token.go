@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// TokenContractName is the name the token contract is served under. Its
+// transactions are called as "token:Transfer" and so on.
+const TokenContractName = "token"
+
+const (
+	// tokenMetadataKey holds the TokenMetadata set by Initialize.
+	tokenMetadataKey = "tokenMetadata"
+
+	// totalSupplyKey holds the total supply.
+	totalSupplyKey = "totalSupply"
+
+	// balanceObjectType is the composite key type of balances, keyed by
+	// account. Accounts without tokens have no key.
+	balanceObjectType = "balance"
+)
+
+const (
+	// TransferEventName is the name of the event raised when tokens move,
+	// including mints from and burns to the empty account.
+	TransferEventName = "Transfer"
+
+	// ApprovalEventName is the name of the event raised when an allowance
+	// is set or revoked.
+	ApprovalEventName = "Approval"
+)
+
+// tokenRoleRequirements lists the token transactions that need a role.
+var tokenRoleRequirements = kalpext.RoleRequirements{
+	"Initialize":     kalpext.DefaultAdminRole,
+	"Mint":           kalpext.DefaultAdminRole,
+	"CreateSnapshot": kalpext.DefaultAdminRole,
+}
+
+// TokenContract is a KRC-20 fungible token.
+type TokenContract struct {
+	kalpsdk.Contract
+}
+
+// TokenMetadata describes the token.
+type TokenMetadata struct {
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+// TransferEvent is the payload of a Transfer event. From is empty for mints
+// and To for burns.
+type TransferEvent struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+// ApprovalEvent is the payload of an Approval event.
+type ApprovalEvent struct {
+	Owner   string `json:"owner"`
+	Spender string `json:"spender"`
+	Value   string `json:"value"`
+}
+
+// Initialize sets the token's name, symbol and decimals. It can be called
+// once.
+func (t *TokenContract) Initialize(ctx kalpsdk.TransactionContextInterface, name string, symbol string, decimals int) error {
+	existing, err := ctx.GetState(tokenMetadataKey)
+	if err != nil {
+		return fmt.Errorf("failed to read token metadata: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("token is already initialized")
+	}
+	if name == "" || symbol == "" {
+		return fmt.Errorf("name and symbol must not be empty")
+	}
+	if decimals < 0 || decimals > 36 {
+		return fmt.Errorf("decimals must be between 0 and 36")
+	}
+	metadataBytes, err := json.Marshal(TokenMetadata{Name: name, Symbol: symbol, Decimals: decimals})
+	if err != nil {
+		return fmt.Errorf("failed to marshal token metadata: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(tokenMetadataKey, metadataBytes); err != nil {
+		return fmt.Errorf("failed to store token metadata: %v", err)
+	}
+	return nil
+}
+
+// GetMetadata returns the token's name, symbol and decimals.
+func (t *TokenContract) GetMetadata(ctx kalpsdk.TransactionContextInterface) (*TokenMetadata, error) {
+	metadataBytes, err := ctx.GetState(tokenMetadataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token metadata: %v", err)
+	}
+	if metadataBytes == nil {
+		return nil, fmt.Errorf("token is not initialized")
+	}
+	var metadata TokenMetadata
+	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token metadata: %v", err)
+	}
+	return &metadata, nil
+}
+
+// TotalSupply returns the number of tokens in existence.
+func (t *TokenContract) TotalSupply(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	supply, err := getAmount(ctx, totalSupplyKey)
+	if err != nil {
+		return "", err
+	}
+	return supply.String(), nil
+}
+
+// BalanceOf returns the tokens held by account.
+func (t *TokenContract) BalanceOf(ctx kalpsdk.TransactionContextInterface, account string) (string, error) {
+	balance, err := getBalance(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	return balance.String(), nil
+}
+
+// Mint creates amount tokens for account.
+func (t *TokenContract) Mint(ctx kalpsdk.TransactionContextInterface, account string, amount string) error {
+	if _, err := t.GetMetadata(ctx); err != nil {
+		return err
+	}
+	if account == "" {
+		return fmt.Errorf("account must not be empty")
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	if err := addSupply(ctx, value); err != nil {
+		return err
+	}
+	return moveTokens(ctx, kalpext.NewEventBuffer(ctx), "", account, value)
+}
+
+// Burn destroys amount of the client's tokens.
+func (t *TokenContract) Burn(ctx kalpsdk.TransactionContextInterface, amount string) error {
+	owner, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	if err := moveTokens(ctx, kalpext.NewEventBuffer(ctx), owner, "", value); err != nil {
+		return err
+	}
+	return addSupply(ctx, new(big.Int).Neg(value))
+}
+
+// Transfer moves amount of the client's tokens to to.
+func (t *TokenContract) Transfer(ctx kalpsdk.TransactionContextInterface, to string, amount string) error {
+	from, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := parsePositiveAmount("amount", amount)
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		return fmt.Errorf("recipient must not be empty")
+	}
+	return moveTokens(ctx, kalpext.NewEventBuffer(ctx), from, to, value)
+}
+
+// moveTokens moves value from one account to another and raises a Transfer
+// event in events. An empty from mints and an empty to burns; the caller
+// adjusts the total supply.
+func moveTokens(ctx kalpsdk.TransactionContextInterface, events *kalpext.EventBuffer, from, to string, value *big.Int) error {
+	if from == to {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+	if from != "" {
+		if err := debitBalance(ctx, from, value); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if err := creditBalance(ctx, to, value); err != nil {
+			return err
+		}
+	}
+	return emitTransfer(events, from, to, value)
+}
+
+// debitBalance takes value from the balance of account.
+func debitBalance(ctx kalpsdk.TransactionContextInterface, account string, value *big.Int) error {
+	balance, err := getBalance(ctx, account)
+	if err != nil {
+		return err
+	}
+	if balance.Cmp(value) < 0 {
+		return fmt.Errorf("account %s has %s tokens, %s are needed", account, balance, value)
+	}
+	return putBalance(ctx, account, balance.Sub(balance, value))
+}
+
+// creditBalance adds value to the balance of account.
+func creditBalance(ctx kalpsdk.TransactionContextInterface, account string, value *big.Int) error {
+	balance, err := getBalance(ctx, account)
+	if err != nil {
+		return err
+	}
+	return putBalance(ctx, account, balance.Add(balance, value))
+}
+
+func emitTransfer(events *kalpext.EventBuffer, from, to string, value *big.Int) error {
+	return events.Emit(TransferEventName, TransferEvent{From: from, To: to, Value: value.String()})
+}
+
+func addSupply(ctx kalpsdk.TransactionContextInterface, delta *big.Int) error {
+	if err := writeCheckpoint(ctx, supplyCheckpointObjectType, []string{}, totalSupplyKey); err != nil {
+		return err
+	}
+	supply, err := getAmount(ctx, totalSupplyKey)
+	if err != nil {
+		return err
+	}
+	supply.Add(supply, delta)
+	if supply.Sign() < 0 {
+		return fmt.Errorf("total supply cannot become negative")
+	}
+	return putAmount(ctx, totalSupplyKey, supply)
+}
+
+func getBalance(ctx kalpsdk.TransactionContextInterface, account string) (*big.Int, error) {
+	key, err := ctx.CreateCompositeKey(balanceObjectType, []string{account})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create balance key: %v", err)
+	}
+	return getAmount(ctx, key)
+}
+
+// putBalance stores the balance of account, first recording its old balance
+// for the latest snapshot.
+func putBalance(ctx kalpsdk.TransactionContextInterface, account string, balance *big.Int) error {
+	key, err := ctx.CreateCompositeKey(balanceObjectType, []string{account})
+	if err != nil {
+		return fmt.Errorf("failed to create balance key: %v", err)
+	}
+	if err := writeCheckpoint(ctx, balanceCheckpointObjectType, []string{account}, key); err != nil {
+		return err
+	}
+	return putAmount(ctx, key, balance)
+}
+
+func clientID(ctx kalpsdk.TransactionContextInterface) (string, error) {
+	userID, err := ctx.GetUserID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client id: %v", err)
+	}
+	return userID, nil
+}
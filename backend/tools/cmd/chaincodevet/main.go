@@ -0,0 +1,21 @@
+// Command chaincodevet checks contract packages for non-deterministic code.
+//
+// Run it directly on a package pattern:
+//
+//	chaincodevet ./...
+//
+// or as a vet tool, which caches results. Functions in _test.go files are
+// never checked, since they do not run on a peer:
+//
+//	go vet -vettool=$(which chaincodevet) ./...
+package main
+
+import (
+	"krc20/tools/determinism"
+
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(determinism.Analyzer)
+}
@@ -0,0 +1,52 @@
+// Command contractcheck reports transaction functions that kalpsdk.NewChaincode
+// would reject at peer startup.
+//
+// Usage:
+//
+//	contractcheck [-warnings=false] [packages]
+//
+// Packages default to "." and are resolved relative to the current directory.
+// The exit status is 1 when any error is found.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"krc20/tools/contractcheck"
+)
+
+func main() {
+	warnings := flag.Bool("warnings", true, "also report fields the serializer drops or the schema requires unexpectedly")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: contractcheck [flags] [packages]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	findings, err := contractcheck.Check("", patterns...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "contractcheck: %v\n", err)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, f := range findings {
+		if f.Severity == contractcheck.Warning && !*warnings {
+			continue
+		}
+		if f.Severity == contractcheck.Error {
+			failed = true
+		}
+		fmt.Println(f)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,415 @@
+// Package contractcheck validates contract transaction functions against the
+// rules contractapi applies when kalpsdk.NewChaincode builds a chaincode.
+//
+// contractapi inspects contracts with reflection at peer startup, so a bad
+// parameter or return type only surfaces when the chaincode container fails to
+// start. Check applies the same rules to the type-checked source instead and
+// reports every offending function with its position:
+//
+//   - the transaction context may only be taken as the first parameter, and an
+//     interface in that position must be satisfied by the context handler
+//   - parameters and returns must be basic types, interface{}, structs, pointers
+//     to structs, arrays, slices or string-keyed maps of these
+//   - at most two values may be returned and the second must be error
+//
+// It also warns about struct fields the metadata and serializer packages treat
+// surprisingly: unexported fields are silently dropped, fields tagged
+// json:",omitempty" or json:"-" are still required by the generated schema.
+package contractcheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const contractapiPath = "github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+// ciMethods are the ContractInterface, IgnoreContractInterface and
+// EvaluationContractInterface methods, which contractapi never exposes.
+var ciMethods = map[string]bool{
+	"GetInfo":                      true,
+	"GetUnknownTransaction":        true,
+	"GetBeforeTransaction":         true,
+	"GetAfterTransaction":          true,
+	"GetName":                      true,
+	"GetTransactionContextHandler": true,
+	"GetIgnoredFunctions":          true,
+	"GetEvaluateTransactions":      true,
+}
+
+// Severity distinguishes findings that stop the chaincode from starting from
+// those that only change how arguments and results are handled.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Finding is a single rule violation in a transaction function.
+type Finding struct {
+	Pos      token.Position
+	Contract string
+	Function string
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s.%s: %s", f.Pos, f.Severity, f.Contract, f.Function, f.Message)
+}
+
+// Check loads the packages matching patterns, relative to dir, and validates
+// every contract type declared in them. Contract types are structs embedding
+// kalpsdk.Contract or contractapi.Contract.
+func Check(dir string, patterns ...string) ([]Finding, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:  dir,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages: %v", err)
+	}
+	if n := packages.PrintErrors(pkgs); n > 0 {
+		return nil, fmt.Errorf("%d errors while loading packages", n)
+	}
+
+	var findings []Finding
+	for _, pkg := range pkgs {
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tn.IsAlias() {
+				continue
+			}
+			named, ok := tn.Type().(*types.Named)
+			if !ok {
+				continue
+			}
+			if handler := contextHandler(named, map[types.Type]bool{}); handler != nil {
+				c := checker{pkg: pkg, contract: tn.Name(), handler: handler}
+				c.checkContract(named)
+				findings = append(findings, c.findings...)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i].Pos, findings[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Line < b.Line
+	})
+	return findings, nil
+}
+
+type checker struct {
+	pkg      *packages.Package
+	contract string
+	handler  types.Type
+	findings []Finding
+}
+
+func (c *checker) report(fn *types.Func, sev Severity, format string, args ...interface{}) {
+	if sev == Warning && fn.Pkg() != c.pkg.Types {
+		// Promoted SDK methods: nothing the contract author can change.
+		return
+	}
+	c.findings = append(c.findings, Finding{
+		Pos:      c.pkg.Fset.Position(fn.Pos()),
+		Contract: c.contract,
+		Function: fn.Name(),
+		Severity: sev,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) checkContract(named *types.Named) {
+	ignored := c.ignoredFunctions(named)
+	mset := types.NewMethodSet(types.NewPointer(named))
+
+	count := 0
+	for i := 0; i < mset.Len(); i++ {
+		fn := mset.At(i).Obj().(*types.Func)
+		if !fn.Exported() || ciMethods[fn.Name()] || ignored[fn.Name()] {
+			continue
+		}
+		count++
+		c.checkFunction(fn)
+	}
+	if count == 0 {
+		c.findings = append(c.findings, Finding{
+			Pos:      c.pkg.Fset.Position(named.Obj().Pos()),
+			Contract: c.contract,
+			Severity: Error,
+			Message:  "contracts are required to have at least 1 (non-ignored) public method",
+		})
+	}
+}
+
+func (c *checker) checkFunction(fn *types.Func) {
+	sig := fn.Type().(*types.Signature)
+
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		t := params.At(i).Type()
+		isCtx := types.Identical(t, c.handler)
+		if !isCtx {
+			if iface, ok := t.Underlying().(*types.Interface); ok && !iface.Empty() {
+				if !types.Implements(c.handler, iface) {
+					if i == 0 {
+						c.report(fn, Error, "contains invalid transaction context interface type: %s is not implemented by the contract's transaction context %s", typeString(t), typeString(c.handler))
+					} else {
+						c.report(fn, Error, "contains invalid parameter type: type %s is not valid, only interface{} may be used", typeString(t))
+					}
+					continue
+				}
+				isCtx = true
+			}
+		}
+		if isCtx {
+			if i != 0 {
+				c.report(fn, Error, "functions requiring the transaction context must require it as the first parameter, takes it as parameter %d", i)
+			}
+			continue
+		}
+		if err := c.typeIsValid(fn, t, false); err != "" {
+			c.report(fn, Error, "contains invalid parameter type: %s", err)
+		}
+	}
+
+	results := sig.Results()
+	switch results.Len() {
+	case 0:
+	case 1:
+		if err := c.typeIsValid(fn, results.At(0).Type(), true); err != "" {
+			c.report(fn, Error, "contains invalid single return type: %s", err)
+		}
+	case 2:
+		if first := results.At(0).Type(); !isError(first) {
+			if err := c.typeIsValid(fn, first, true); err != "" {
+				c.report(fn, Error, "contains invalid first return type: %s", err)
+			}
+		}
+		if second := results.At(1).Type(); !isError(second) {
+			c.report(fn, Error, "contains invalid second return type: type %s is not valid, expected error", typeString(second))
+		}
+	default:
+		c.report(fn, Error, "functions may only return a maximum of two values, returns %d", results.Len())
+	}
+}
+
+// typeIsValid mirrors contractapi's internal typeIsValid and returns a
+// description of the problem, or "" when t may be used. Struct fields that
+// the metadata package handles surprisingly are reported as warnings.
+func (c *checker) typeIsValid(fn *types.Func, t types.Type, allowError bool) string {
+	return c.validate(fn, t, allowError, map[types.Type]bool{})
+}
+
+func (c *checker) validate(fn *types.Func, t types.Type, allowError bool, seen map[types.Type]bool) string {
+	if isError(t) {
+		if allowError {
+			return ""
+		}
+		return "type error is not valid here"
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Info()&(types.IsBoolean|types.IsInteger|types.IsFloat|types.IsString) == 0 || u.Kind() == types.Uintptr {
+			return fmt.Sprintf("type %s is not valid, expected a struct or one of the basic types bool, float32, float64, int, int8, int16, int32, int64, string, uint, uint8, uint16, uint32, uint64 or interface{}, or an array/slice of these", typeString(t))
+		}
+		return ""
+	case *types.Interface:
+		if _, named := t.(*types.Named); named || !u.Empty() {
+			return fmt.Sprintf("type %s is not valid, only interface{} may be used", typeString(t))
+		}
+		return ""
+	case *types.Array:
+		if u.Len() < 1 {
+			return "arrays must have length greater than 0"
+		}
+		return c.validate(fn, u.Elem(), false, seen)
+	case *types.Slice:
+		return c.validate(fn, u.Elem(), false, seen)
+	case *types.Map:
+		if k, ok := u.Key().Underlying().(*types.Basic); !ok || k.Kind() != types.String {
+			return fmt.Sprintf("map key type %s is not valid, expected string", typeString(u.Key()))
+		}
+		return c.validate(fn, u.Elem(), false, seen)
+	case *types.Pointer:
+		// The metadata package special-cases time.Time but not *time.Time,
+		// whose schema comes out as an empty object the chaincode rejects at
+		// startup.
+		if isTime(u.Elem()) {
+			return fmt.Sprintf("type %s is not valid, use time.Time or an RFC 3339 string", typeString(t))
+		}
+		if _, ok := u.Elem().Underlying().(*types.Struct); ok {
+			return c.validate(fn, u.Elem(), false, seen)
+		}
+	case *types.Struct:
+		if seen[t] {
+			return ""
+		}
+		seen[t] = true
+		if isTime(t) {
+			return ""
+		}
+		for i := 0; i < u.NumFields(); i++ {
+			field := u.Field(i)
+			tag := reflectTag(u.Tag(i))
+			if !field.Exported() && tag.metadata == "" {
+				if !field.Embedded() {
+					c.report(fn, Warning, "field %s of %s is unexported and is ignored by the serializer and metadata", field.Name(), typeString(t))
+				}
+				continue
+			}
+			c.checkTags(fn, t, field, tag)
+			if err := c.validate(fn, field.Type(), false, seen); err != "" {
+				return err
+			}
+		}
+		// The metadata package always emits a required list, and the
+		// metadata schema rejects an empty one.
+		if requiredFields(u) == 0 {
+			return fmt.Sprintf("type %s is not valid, the metadata schema needs at least one field that is not metadata:\",optional\"", typeString(t))
+		}
+		return ""
+	}
+	return fmt.Sprintf("type %s is not valid, expected a struct or one of the basic types or an array/slice of these", typeString(t))
+}
+
+// checkTags warns about fields the generated schema requires although the
+// JSON encoding may leave them out.
+func (c *checker) checkTags(fn *types.Func, owner types.Type, field *types.Var, tag structTag) {
+	if field.Embedded() || strings.Contains(tag.metadata, "optional") || tag.metadata == "-" {
+		return
+	}
+	name, opts, _ := strings.Cut(tag.json, ",")
+	switch {
+	case name == "-" && opts == "":
+		c.report(fn, Warning, "field %s of %s is tagged json:\"-\" but still required by the schema, tag it metadata:\"-\"", field.Name(), typeString(owner))
+	case strings.Contains(opts, "omitempty"):
+		c.report(fn, Warning, "field %s of %s is omitempty but required by the schema, add metadata:\",optional\"", field.Name(), typeString(owner))
+	}
+}
+
+// ignoredFunctions returns the names listed by a GetIgnoredFunctions method
+// declared on the contract when it returns a literal slice of strings.
+func (c *checker) ignoredFunctions(named *types.Named) map[string]bool {
+	ignored := map[string]bool{}
+	for _, file := range c.pkg.Syntax {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || fd.Name.Name != "GetIgnoredFunctions" || fd.Body == nil {
+				continue
+			}
+			recv := c.pkg.TypesInfo.TypeOf(fd.Recv.List[0].Type)
+			if ptr, ok := recv.(*types.Pointer); ok {
+				recv = ptr.Elem()
+			}
+			if !types.Identical(recv, named) {
+				continue
+			}
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					if s, err := strconv.Unquote(lit.Value); err == nil {
+						ignored[s] = true
+					}
+				}
+				return true
+			})
+		}
+	}
+	return ignored
+}
+
+// contextHandler returns the default transaction context handler of the
+// embedded kalpsdk or contractapi Contract, or nil when t is not a contract.
+func contextHandler(t types.Type, seen map[types.Type]bool) types.Type {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	if named, ok := t.(*types.Named); ok && named.Obj().Name() == "Contract" && named.Obj().Pkg() != nil {
+		pkg := named.Obj().Pkg()
+		if pkg.Path() == contractapiPath || strings.HasSuffix(pkg.Path(), "/kalpsdk") {
+			if tn, ok := pkg.Scope().Lookup("TransactionContext").(*types.TypeName); ok {
+				return types.NewPointer(tn.Type())
+			}
+		}
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		if f := st.Field(i); f.Embedded() {
+			if h := contextHandler(f.Type(), seen); h != nil {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// requiredFields counts the fields the metadata package lists as required
+// for st, including those of embedded structs.
+func requiredFields(st *types.Struct) int {
+	n := 0
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		if field.Embedded() {
+			if embedded, ok := field.Type().Underlying().(*types.Struct); ok {
+				n += requiredFields(embedded)
+			}
+			continue
+		}
+		name, opts, _ := strings.Cut(reflectTag(st.Tag(i)).metadata, ",")
+		if (!field.Exported() && name == "") || name == "-" {
+			continue
+		}
+		if !strings.Contains(","+strings.ReplaceAll(opts, " ", "")+",", ",optional,") {
+			n++
+		}
+	}
+	return n
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+func isTime(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "time" && named.Obj().Name() == "Time"
+}
+
+func typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string { return p.Name() })
+}
+
+type structTag struct {
+	json     string
+	metadata string
+}
+
+func reflectTag(tag string) structTag {
+	st := reflect.StructTag(tag)
+	return structTag{json: st.Get("json"), metadata: st.Get("metadata")}
+}
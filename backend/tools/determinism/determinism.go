@@ -0,0 +1,272 @@
+// Package determinism defines an Analyzer that reports constructs which make
+// chaincode transactions produce different results on different endorsing peers.
+//
+// Every endorser executes a transaction independently and the resulting read/write
+// sets must match byte for byte, so transaction code may only depend on world state
+// and on the transaction proposal itself. The analyzer inspects the methods of
+// contract types (structs embedding kalpsdk.Contract or contractapi.Contract) and
+// every function that receives a transaction context, and flags:
+//
+//   - wall-clock reads such as time.Now (use ctx.GetTxTimestamp instead)
+//   - math/rand and crypto/rand (derive values from the transaction instead)
+//   - go statements
+//   - ranging over a map while writing state, emitting events or appending
+//     to a result (collect and sort the keys first)
+//   - network, file system, process and environment access
+package determinism
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const doc = `report non-deterministic constructs in chaincode
+
+The determinism analyzer flags wall-clock reads, random numbers, goroutines,
+map iteration that feeds state or results, and network/file/process access
+inside contract methods and functions that take a transaction context.
+Endorsing peers must compute identical read/write sets, so these constructs
+lead to endorsement mismatches at best and forked application state at worst.`
+
+// Analyzer reports non-deterministic constructs in chaincode.
+var Analyzer = &analysis.Analyzer{
+	Name:     "determinism",
+	Doc:      doc,
+	URL:      "https://hyperledger-fabric.readthedocs.io/en/latest/chaincode4ade.html",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+const contractapiPath = "github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+// clockFuncs are the time functions that read the local wall clock.
+var clockFuncs = map[string]bool{
+	"Now":   true,
+	"Since": true,
+	"Until": true,
+}
+
+// ioPackages are packages whose functions and methods reach outside the
+// process or depend on the host the peer happens to run on.
+var ioPackages = map[string]bool{
+	"net":       true,
+	"os":        true,
+	"os/exec":   true,
+	"os/signal": true,
+	"io/ioutil": true,
+	"syscall":   true,
+}
+
+// stateWriters are the transaction context and stub methods that add to the
+// write set or to the transaction response.
+var stateWriters = map[string]bool{
+	"PutStateWithKYC":    true,
+	"PutStateWithoutKYC": true,
+	"DelStateWithKYC":    true,
+	"DelStateWithoutKYC": true,
+	"PutState":           true,
+	"DelState":           true,
+	"PutPrivateData":     true,
+	"DelPrivateData":     true,
+	"SetEvent":           true,
+	"PutKYC":             true,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	inspect.Preorder([]ast.Node{(*ast.FuncDecl)(nil)}, func(n ast.Node) {
+		fn := n.(*ast.FuncDecl)
+		if fn.Body == nil || strings.HasSuffix(pass.Fset.File(fn.Pos()).Name(), "_test.go") {
+			return
+		}
+		if !isContractMethod(pass, fn) && !takesTransactionContext(pass, fn.Type) {
+			return
+		}
+		checkBody(pass, fn.Body)
+	})
+	return nil, nil
+}
+
+func checkBody(pass *analysis.Pass, body *ast.BlockStmt) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GoStmt:
+			pass.Reportf(n.Go, "goroutine in chaincode: scheduling differs between endorsers, run the work inline")
+		case *ast.CallExpr:
+			checkCall(pass, n)
+		case *ast.RangeStmt:
+			checkRange(pass, body, n)
+		}
+		return true
+	})
+}
+
+func checkCall(pass *analysis.Pass, call *ast.CallExpr) {
+	fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return
+	}
+	path := fn.Pkg().Path()
+	switch {
+	case path == "time" && clockFuncs[fn.Name()] && !isMethod(fn):
+		pass.Reportf(call.Pos(), "time.%s reads the endorser's wall clock: use ctx.GetTxTimestamp() for the transaction time", fn.Name())
+	case path == "math/rand" || path == "math/rand/v2" || path == "crypto/rand":
+		pass.Reportf(call.Pos(), "%s.%s returns different values on every endorser: derive values from the transaction inputs or ctx.GetTxID()", path, fn.Name())
+	case ioPackages[path] || strings.HasPrefix(path, "net/"):
+		pass.Reportf(call.Pos(), "%s.%s performs network, file or host access: chaincode may only depend on world state and transaction inputs", path, fn.Name())
+	}
+}
+
+// checkRange reports ranging over a map whose body writes state, emits
+// events or appends to a slice, since Go randomises map iteration order.
+// Collecting the keys into a slice that is sorted elsewhere in the same
+// function is the recommended fix and is not reported.
+func checkRange(pass *analysis.Pass, scope *ast.BlockStmt, rng *ast.RangeStmt) {
+	if _, ok := pass.TypesInfo.TypeOf(rng.X).Underlying().(*types.Map); !ok {
+		return
+	}
+	if target := collectedKeys(pass, rng); target != nil && isSorted(pass, scope, target) {
+		return
+	}
+
+	var what string
+	ast.Inspect(rng.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || what != "" {
+			return what == ""
+		}
+		if id, ok := call.Fun.(*ast.Ident); ok {
+			if b, ok := pass.TypesInfo.Uses[id].(*types.Builtin); ok && b.Name() == "append" {
+				what = "builds a slice"
+			}
+			return true
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok && stateWriters[sel.Sel.Name] {
+			what = "calls " + sel.Sel.Name
+		}
+		return true
+	})
+	if what != "" {
+		pass.Reportf(rng.For, "range over map %s in random order: collect the keys, sort them and iterate the sorted keys", what)
+	}
+}
+
+// collectedKeys returns the slice variable when the loop body is exactly
+// `s = append(s, k)` with k being the range key.
+func collectedKeys(pass *analysis.Pass, rng *ast.RangeStmt) types.Object {
+	key, ok := rng.Key.(*ast.Ident)
+	if !ok || len(rng.Body.List) != 1 {
+		return nil
+	}
+	assign, ok := rng.Body.List[0].(*ast.AssignStmt)
+	if !ok || assign.Tok != token.ASSIGN || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+		return nil
+	}
+	call, ok := assign.Rhs[0].(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return nil
+	}
+	if id, ok := call.Fun.(*ast.Ident); !ok || id.Name != "append" {
+		return nil
+	}
+	if arg, ok := call.Args[1].(*ast.Ident); !ok || pass.TypesInfo.ObjectOf(arg) != pass.TypesInfo.ObjectOf(key) {
+		return nil
+	}
+	lhs, ok := assign.Lhs[0].(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	return pass.TypesInfo.ObjectOf(lhs)
+}
+
+// isSorted reports whether scope contains a sort or slices call whose first
+// argument is obj.
+func isSorted(pass *analysis.Pass, scope *ast.BlockStmt, obj types.Object) bool {
+	sorted := false
+	ast.Inspect(scope, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || sorted || len(call.Args) == 0 {
+			return !sorted
+		}
+		fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+		if !ok || fn.Pkg() == nil {
+			return true
+		}
+		switch path := fn.Pkg().Path(); {
+		case path == "sort", path == "slices", strings.HasSuffix(path, "/exp/slices"):
+			if id, ok := call.Args[0].(*ast.Ident); ok && pass.TypesInfo.ObjectOf(id) == obj {
+				sorted = true
+			}
+		}
+		return true
+	})
+	return sorted
+}
+
+func isMethod(fn *types.Func) bool {
+	return fn.Type().(*types.Signature).Recv() != nil
+}
+
+// isContractMethod reports whether fn is declared on a struct that embeds
+// kalpsdk.Contract or contractapi.Contract.
+func isContractMethod(pass *analysis.Pass, fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return false
+	}
+	return embedsContract(pass.TypesInfo.TypeOf(fn.Recv.List[0].Type), map[types.Type]bool{})
+}
+
+func embedsContract(t types.Type, seen map[types.Type]bool) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	if isSDKType(t, "Contract") {
+		return true
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return false
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		if f := st.Field(i); f.Embedded() && embedsContract(f.Type(), seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// takesTransactionContext reports whether any parameter is a kalpsdk or
+// contractapi transaction context.
+func takesTransactionContext(pass *analysis.Pass, ft *ast.FuncType) bool {
+	for _, field := range ft.Params.List {
+		t := pass.TypesInfo.TypeOf(field.Type)
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if isSDKType(t, "TransactionContextInterface") || isSDKType(t, "TransactionContext") {
+			return true
+		}
+	}
+	return false
+}
+
+func isSDKType(t types.Type, name string) bool {
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Name() != name {
+		return false
+	}
+	path := named.Obj().Pkg().Path()
+	return path == contractapiPath || strings.HasSuffix(path, "/kalpsdk")
+}
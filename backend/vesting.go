@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+
+	"krc20/kalpext"
+)
+
+// VestingContractName is the name the vesting contract is served under.
+const VestingContractName = "vesting"
+
+const (
+	// vestingScheduleObjectType is the composite key type of vesting
+	// schedules, keyed by beneficiary and schedule ID.
+	vestingScheduleObjectType = "vestingSchedule"
+
+	// vestingAccountPrefix starts the token account that holds the
+	// unreleased tokens of a schedule.
+	vestingAccountPrefix = "vesting/"
+)
+
+const (
+	// VestingScheduleCreatedEventName is the name of the event raised by
+	// CreateVestingSchedule.
+	VestingScheduleCreatedEventName = "VestingScheduleCreated"
+
+	// TokensReleasedEventName is the name of the event raised by Release.
+	TokensReleasedEventName = "TokensReleased"
+
+	// VestingRevokedEventName is the name of the event raised by
+	// RevokeVestingSchedule.
+	VestingRevokedEventName = "VestingRevoked"
+)
+
+// vestingRoleRequirements lists the vesting transactions that need a role.
+var vestingRoleRequirements = kalpext.RoleRequirements{
+	"CreateVestingSchedule": kalpext.DefaultAdminRole,
+	"RevokeVestingSchedule": kalpext.DefaultAdminRole,
+}
+
+// VestingContract locks tokens for beneficiaries and releases them linearly
+// over time.
+type VestingContract struct {
+	kalpsdk.Contract
+}
+
+// VestingSchedule vests Total tokens linearly from Start over
+// DurationSeconds. Nothing vests before the cliff, CliffSeconds after Start.
+// Account holds the tokens not yet released. A revoked schedule keeps what
+// had vested when it was revoked as its Total.
+type VestingSchedule struct {
+	ID              string `json:"id"`
+	Beneficiary     string `json:"beneficiary"`
+	Total           string `json:"total"`
+	Released        string `json:"released"`
+	Start           string `json:"start"`
+	CliffSeconds    int64  `json:"cliffSeconds"`
+	DurationSeconds int64  `json:"durationSeconds"`
+	Revocable       bool   `json:"revocable"`
+	Revoked         bool   `json:"revoked"`
+	RevokedAt       string `json:"revokedAt,omitempty" metadata:",optional"`
+	Account         string `json:"account"`
+}
+
+// VestingScheduleCreatedEvent is the payload of a VestingScheduleCreated
+// event.
+type VestingScheduleCreatedEvent struct {
+	ScheduleID      string `json:"scheduleId"`
+	Beneficiary     string `json:"beneficiary"`
+	Total           string `json:"total"`
+	Start           string `json:"start"`
+	CliffSeconds    int64  `json:"cliffSeconds"`
+	DurationSeconds int64  `json:"durationSeconds"`
+	Revocable       bool   `json:"revocable"`
+	Account         string `json:"account"`
+}
+
+// TokensReleasedEvent is the payload of a TokensReleased event.
+type TokensReleasedEvent struct {
+	ScheduleID  string `json:"scheduleId"`
+	Beneficiary string `json:"beneficiary"`
+	Amount      string `json:"amount"`
+}
+
+// VestingRevokedEvent is the payload of a VestingRevoked event. Unvested is
+// returned to the admin.
+type VestingRevokedEvent struct {
+	ScheduleID  string `json:"scheduleId"`
+	Beneficiary string `json:"beneficiary"`
+	Unvested    string `json:"unvested"`
+}
+
+// CreateVestingSchedule moves total of the client's tokens into a schedule
+// for beneficiary starting at start, an RFC 3339 time. It returns the
+// schedule ID, the ID of the transaction.
+func (v *VestingContract) CreateVestingSchedule(ctx kalpsdk.TransactionContextInterface, beneficiary string, total string, start string, cliffSeconds int64, durationSeconds int64, revocable bool) (string, error) {
+	admin, err := clientID(ctx)
+	if err != nil {
+		return "", err
+	}
+	if beneficiary == "" {
+		return "", fmt.Errorf("beneficiary must not be empty")
+	}
+	value, err := parsePositiveAmount("total", total)
+	if err != nil {
+		return "", err
+	}
+	startTime, err := parseOptionalTime("start", start)
+	if err != nil {
+		return "", err
+	}
+	if startTime.IsZero() {
+		return "", fmt.Errorf("start must not be empty")
+	}
+	if durationSeconds <= 0 {
+		return "", fmt.Errorf("duration must be positive")
+	}
+	if cliffSeconds < 0 || cliffSeconds > durationSeconds {
+		return "", fmt.Errorf("cliff must be between 0 and the duration")
+	}
+	schedule := &VestingSchedule{
+		ID:              ctx.GetTxID(),
+		Beneficiary:     beneficiary,
+		Total:           value.String(),
+		Released:        "0",
+		Start:           formatOptionalTime(startTime),
+		CliffSeconds:    cliffSeconds,
+		DurationSeconds: durationSeconds,
+		Revocable:       revocable,
+	}
+	schedule.Account = vestingAccountPrefix + schedule.ID
+	if err := putVestingSchedule(ctx, schedule); err != nil {
+		return "", err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, admin, schedule.Account, value); err != nil {
+		return "", err
+	}
+	if err := events.Emit(VestingScheduleCreatedEventName, VestingScheduleCreatedEvent{
+		ScheduleID:      schedule.ID,
+		Beneficiary:     schedule.Beneficiary,
+		Total:           schedule.Total,
+		Start:           schedule.Start,
+		CliffSeconds:    schedule.CliffSeconds,
+		DurationSeconds: schedule.DurationSeconds,
+		Revocable:       schedule.Revocable,
+		Account:         schedule.Account,
+	}); err != nil {
+		return "", err
+	}
+	return schedule.ID, nil
+}
+
+// Release moves the tokens of the client's schedule that have vested and not
+// been released yet to the client.
+func (v *VestingContract) Release(ctx kalpsdk.TransactionContextInterface, scheduleID string) (string, error) {
+	beneficiary, err := clientID(ctx)
+	if err != nil {
+		return "", err
+	}
+	schedule, err := requireVestingSchedule(ctx, beneficiary, scheduleID)
+	if err != nil {
+		return "", err
+	}
+	releasable, err := schedule.releasableAt(ctx)
+	if err != nil {
+		return "", err
+	}
+	if releasable.Sign() == 0 {
+		return "", fmt.Errorf("no tokens of schedule %s are releasable", scheduleID)
+	}
+	released, err := parseAmount("released", schedule.Released)
+	if err != nil {
+		return "", err
+	}
+	schedule.Released = released.Add(released, releasable).String()
+	if err := putVestingSchedule(ctx, schedule); err != nil {
+		return "", err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if err := moveTokens(ctx, events, schedule.Account, beneficiary, releasable); err != nil {
+		return "", err
+	}
+	if err := events.Emit(TokensReleasedEventName, TokensReleasedEvent{ScheduleID: scheduleID, Beneficiary: beneficiary, Amount: releasable.String()}); err != nil {
+		return "", err
+	}
+	return releasable.String(), nil
+}
+
+// RevokeVestingSchedule stops a revocable schedule and returns its unvested
+// tokens to the client. What has vested stays releasable.
+func (v *VestingContract) RevokeVestingSchedule(ctx kalpsdk.TransactionContextInterface, beneficiary string, scheduleID string) error {
+	admin, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	schedule, err := requireVestingSchedule(ctx, beneficiary, scheduleID)
+	if err != nil {
+		return err
+	}
+	if !schedule.Revocable {
+		return fmt.Errorf("schedule %s is not revocable", scheduleID)
+	}
+	if schedule.Revoked {
+		return fmt.Errorf("schedule %s is already revoked", scheduleID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	vested, err := schedule.vestedAt(now)
+	if err != nil {
+		return err
+	}
+	total, err := parseAmount("total", schedule.Total)
+	if err != nil {
+		return err
+	}
+	unvested := total.Sub(total, vested)
+	schedule.Total = vested.String()
+	schedule.Revoked = true
+	schedule.RevokedAt = formatOptionalTime(now)
+	if err := putVestingSchedule(ctx, schedule); err != nil {
+		return err
+	}
+	events := kalpext.NewEventBuffer(ctx)
+	if unvested.Sign() > 0 {
+		if err := moveTokens(ctx, events, schedule.Account, admin, unvested); err != nil {
+			return err
+		}
+	}
+	return events.Emit(VestingRevokedEventName, VestingRevokedEvent{ScheduleID: scheduleID, Beneficiary: beneficiary, Unvested: unvested.String()})
+}
+
+// GetVestingSchedule returns a schedule of beneficiary.
+func (v *VestingContract) GetVestingSchedule(ctx kalpsdk.TransactionContextInterface, beneficiary string, scheduleID string) (*VestingSchedule, error) {
+	return requireVestingSchedule(ctx, beneficiary, scheduleID)
+}
+
+// GetVestingSchedules returns every schedule of beneficiary.
+func (v *VestingContract) GetVestingSchedules(ctx kalpsdk.TransactionContextInterface, beneficiary string) ([]VestingSchedule, error) {
+	if beneficiary == "" {
+		return nil, fmt.Errorf("beneficiary must not be empty")
+	}
+	iterator, err := ctx.GetStateByPartialCompositeKey(vestingScheduleObjectType, []string{beneficiary})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vesting schedules: %v", err)
+	}
+	defer iterator.Close()
+
+	schedules := []VestingSchedule{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vesting schedules: %v", err)
+		}
+		var schedule VestingSchedule
+		if err := json.Unmarshal(kv.Value, &schedule); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal vesting schedule: %v", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// VestedAmount returns the tokens of a schedule vested as of the transaction
+// timestamp, released or not.
+func (v *VestingContract) VestedAmount(ctx kalpsdk.TransactionContextInterface, beneficiary string, scheduleID string) (string, error) {
+	schedule, err := requireVestingSchedule(ctx, beneficiary, scheduleID)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	vested, err := schedule.vestedAt(now)
+	if err != nil {
+		return "", err
+	}
+	return vested.String(), nil
+}
+
+// ReleasedAmount returns the tokens of a schedule released so far.
+func (v *VestingContract) ReleasedAmount(ctx kalpsdk.TransactionContextInterface, beneficiary string, scheduleID string) (string, error) {
+	schedule, err := requireVestingSchedule(ctx, beneficiary, scheduleID)
+	if err != nil {
+		return "", err
+	}
+	return schedule.Released, nil
+}
+
+// ReleasableAmount returns the tokens of a schedule Release would move now.
+func (v *VestingContract) ReleasableAmount(ctx kalpsdk.TransactionContextInterface, beneficiary string, scheduleID string) (string, error) {
+	schedule, err := requireVestingSchedule(ctx, beneficiary, scheduleID)
+	if err != nil {
+		return "", err
+	}
+	releasable, err := schedule.releasableAt(ctx)
+	if err != nil {
+		return "", err
+	}
+	return releasable.String(), nil
+}
+
+// vestedAt returns the tokens vested at now. A revoked schedule has vested
+// its whole remaining Total.
+func (s *VestingSchedule) vestedAt(now time.Time) (*big.Int, error) {
+	total, err := parseAmount("total", s.Total)
+	if err != nil {
+		return nil, err
+	}
+	if s.Revoked {
+		return total, nil
+	}
+	start, err := parseOptionalTime("start", s.Start)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := int64(now.Sub(start) / time.Second)
+	switch {
+	case elapsed < s.CliffSeconds || elapsed <= 0:
+		return new(big.Int), nil
+	case elapsed >= s.DurationSeconds:
+		return total, nil
+	}
+	vested := total.Mul(total, big.NewInt(elapsed))
+	return vested.Quo(vested, big.NewInt(s.DurationSeconds)), nil
+}
+
+// releasableAt returns the vested tokens not yet released as of the
+// transaction timestamp.
+func (s *VestingSchedule) releasableAt(ctx kalpsdk.TransactionContextInterface) (*big.Int, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vested, err := s.vestedAt(now)
+	if err != nil {
+		return nil, err
+	}
+	released, err := parseAmount("released", s.Released)
+	if err != nil {
+		return nil, err
+	}
+	return vested.Sub(vested, released), nil
+}
+
+func requireVestingSchedule(ctx kalpsdk.TransactionContextInterface, beneficiary, scheduleID string) (*VestingSchedule, error) {
+	key, err := ctx.CreateCompositeKey(vestingScheduleObjectType, []string{beneficiary, scheduleID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vesting schedule key: %v", err)
+	}
+	scheduleBytes, err := ctx.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vesting schedule: %v", err)
+	}
+	if scheduleBytes == nil {
+		return nil, fmt.Errorf("%s has no vesting schedule %s", beneficiary, scheduleID)
+	}
+	var schedule VestingSchedule
+	if err := json.Unmarshal(scheduleBytes, &schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vesting schedule: %v", err)
+	}
+	return &schedule, nil
+}
+
+func putVestingSchedule(ctx kalpsdk.TransactionContextInterface, schedule *VestingSchedule) error {
+	key, err := ctx.CreateCompositeKey(vestingScheduleObjectType, []string{schedule.Beneficiary, schedule.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create vesting schedule key: %v", err)
+	}
+	scheduleBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vesting schedule: %v", err)
+	}
+	if err := ctx.PutStateWithoutKYC(key, scheduleBytes); err != nil {
+		return fmt.Errorf("failed to store vesting schedule: %v", err)
+	}
+	return nil
+}
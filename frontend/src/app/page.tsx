@@ -3,17 +3,89 @@
 import { useState, useEffect } from 'react'
 import { useKalpApi } from '../hooks/useKalpAPI'
 
+interface Greeting {
+  text: string
+  author: string
+  txId: string
+  timestamp: string
+}
+
+// The gateway wraps a function's return value as { result: value }, sent
+// either as an object or as a JSON string.
+const unwrapResult = (result: any) => {
+  if (typeof result === 'string') {
+    try {
+      result = JSON.parse(result)
+    } catch {
+      return undefined
+    }
+  }
+  return result && typeof result === 'object' ? result.result : undefined
+}
+
+// pickLocalizedGreeting returns the greeting for the first browser language
+// that has one, trying each language without its region too. It does not fall
+// back to the contract's default locale, so that the current greeting set
+// with SetGreeting is shown when no browser language matches.
+const pickLocalizedGreeting = (localized: any): string | undefined => {
+  const greetings: { [locale: string]: string } = {}
+  for (const [locale, text] of Object.entries(localized?.greetings || {})) {
+    greetings[locale.toLowerCase()] = text as string
+  }
+  for (const candidate of navigator.languages) {
+    const parts = candidate.toLowerCase().split('-')
+    for (let n = parts.length; n > 0; n--) {
+      const text = greetings[parts.slice(0, n).join('-')]
+      if (text) {
+        return text
+      }
+    }
+  }
+  return undefined
+}
+
+// The contract starts the message of errors caused by missing KYC with this
+// code, see KYCRequiredCode in backend/kyc.go.
+const KYC_REQUIRED = 'KYC_REQUIRED'
+
 export default function Home() {
   const [greeting, setGreeting] = useState('')
   const [newGreeting, setNewGreeting] = useState('')
-  const { getGreeting, setGreeting: updateGreeting, loading, error } = useKalpApi()
+  const [wall, setWall] = useState<Greeting[]>([])
+  const [bookmark, setBookmark] = useState('')
+  const [kycRequired, setKycRequired] = useState(false)
+  const { getGreeting, setGreeting: updateGreeting, listGreetings, getLocalizedGreetings, loading, error } = useKalpApi()
 
   useEffect(() => {
     handleGetGreeting()
+    handleListGreetings()
   }, [])
 
+  const handleListGreetings = async (after: string = '') => {
+    try {
+      const response = await listGreetings(after)
+      const page = unwrapResult(response?.result)
+      if (page && Array.isArray(page.greetings)) {
+        // A later page replaces any entry of the same author, which may
+        // have been updated since the earlier page was loaded.
+        setWall(after ? (current) => {
+          const authors = new Set(page.greetings.map((g: Greeting) => g.author))
+          return [...current.filter((g) => !authors.has(g.author)), ...page.greetings]
+        } : page.greetings)
+        setBookmark(page.bookmark || '')
+      }
+    } catch (err) {
+      console.error("Failed to list greetings", err)
+    }
+  }
+
   const handleGetGreeting = async () => {
     try {
+      const localized = pickLocalizedGreeting(unwrapResult((await getLocalizedGreetings())?.result))
+      if (localized) {
+        setGreeting(localized)
+        return
+      }
       const response = await getGreeting()
       console.log('Get Greeting Response:', response);
       if (response && response.result) {
@@ -50,10 +122,13 @@ export default function Home() {
     try {
       const response = await updateGreeting(newGreeting)
       console.log('Set Greeting Response:', response);
+      setKycRequired(false)
       setNewGreeting('')
       handleGetGreeting()
-    } catch (err) {
+      handleListGreetings()
+    } catch (err: any) {
       console.error("Failed to set greeting", err)
+      setKycRequired(String(err?.message).includes(KYC_REQUIRED))
     }
   }
 
@@ -86,6 +161,15 @@ export default function Home() {
 
       <div className="bg-white shadow-md rounded-lg p-6">
         <h3 className="text-xl font-semibold mb-4">Set New Greeting</h3>
+        {kycRequired && (
+          <div className="bg-yellow-100 border border-yellow-400 text-yellow-800 px-4 py-3 rounded mb-4" role="alert">
+            Setting a greeting requires a verified identity.{' '}
+            <a href="https://console.kalp.studio/" target="_blank" rel="noopener noreferrer" className="underline font-semibold">
+              Complete KYC in Kalp Studio
+            </a>{' '}
+            and try again.
+          </div>
+        )}
         <div className="flex items-center">
           <input
             type="text"
@@ -103,6 +187,33 @@ export default function Home() {
           </button>
         </div>
       </div>
+
+      <div className="bg-white shadow-md rounded-lg p-6 mt-8">
+        <h3 className="text-xl font-semibold mb-4">Greeting Wall</h3>
+        {wall.length === 0 ? (
+          <p className="text-gray-500">No greetings yet</p>
+        ) : (
+          <ul className="divide-y">
+            {wall.map((entry) => (
+              <li key={entry.txId} className="py-3">
+                <p className="text-lg">{entry.text}</p>
+                <p className="text-sm text-gray-500">
+                  {entry.author} &middot; {new Date(entry.timestamp).toLocaleString()}
+                </p>
+              </li>
+            ))}
+          </ul>
+        )}
+        {bookmark && (
+          <button
+            onClick={() => handleListGreetings(bookmark)}
+            className="btn btn-primary mt-4"
+            disabled={loading}
+          >
+            {loading ? 'Loading...' : 'Load More'}
+          </button>
+        )}
+      </div>
     </div>
   )
 }
\ No newline at end of file
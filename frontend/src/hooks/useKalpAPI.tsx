@@ -69,5 +69,16 @@ export const useKalpApi = () => {
     return callApi(endpoint, args);
   };
 
-  return { getGreeting, setGreeting, loading, error };
+  const listGreetings = async (bookmark: string = '', pageSize: number = 20) => {
+    const endpoint = `https://gateway-api.kalp.studio/v1/contract/kalp/query/${contractId}/ListGreetings`;
+    const args = { bookmark, pageSize };
+    return callApi(endpoint, args);
+  };
+
+  const getLocalizedGreetings = async () => {
+    const endpoint = `https://gateway-api.kalp.studio/v1/contract/kalp/query/${contractId}/GetLocalizedGreetings`;
+    return callApi(endpoint);
+  };
+
+  return { getGreeting, setGreeting, listGreetings, getLocalizedGreetings, loading, error };
 };
\ No newline at end of file